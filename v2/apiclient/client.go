@@ -0,0 +1,236 @@
+// Package apiclient is HTTP client of services speaking JSONResponse envelope of chanyut/core/v2
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	core "chanyut/core/v2"
+)
+
+// DefaultMaxResponseSize is maximum size in bytes of response body read by Client
+const DefaultMaxResponseSize = 10 << 20
+
+// Client performs requests and decodes JSONResponse, errors returned by the server either in the envelope
+// or as problem document are returned as *core.APIError
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// Header is sent with every request
+	Header http.Header
+	// Timeout of each attempt, zero means no timeout other than ctx
+	Timeout time.Duration
+	// MaxRetries is number of retries on retryable status (408, 429, 502, 503, 504) or network error
+	MaxRetries int
+	// Backoff is waiting time before the first retry, it doubles on every retry up to MaxBackoff.
+	// Retry-After header of the response is used instead when it is present, it is also capped by MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// MaxResponseSize is maximum size in bytes of response body, larger responses fail without being decoded
+	MaxResponseSize int64
+	// RequestIDHeader is header the request id returned by RequestID is sent in
+	RequestIDHeader string
+	RequestID       func(ctx context.Context) string
+}
+
+// New creates client with default settings
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:         strings.TrimSuffix(baseURL, "/"),
+		HTTPClient:      http.DefaultClient,
+		Header:          http.Header{},
+		Timeout:         10 * time.Second,
+		MaxRetries:      2,
+		Backoff:         100 * time.Millisecond,
+		MaxBackoff:      2 * time.Second,
+		MaxResponseSize: DefaultMaxResponseSize,
+		RequestIDHeader: core.RequestIDHeader,
+		RequestID:       core.RequestIDFromContext,
+	}
+}
+
+// Get sends GET request and decodes data into out
+func (c *Client) Get(ctx context.Context, path string, out interface{}) error {
+	return c.Do(ctx, http.MethodGet, path, nil, out)
+}
+
+// Post sends body as JSON and decodes data into out
+func (c *Client) Post(ctx context.Context, path string, body interface{}, out interface{}) error {
+	return c.Do(ctx, http.MethodPost, path, body, out)
+}
+
+// Put sends body as JSON and decodes data into out
+func (c *Client) Put(ctx context.Context, path string, body interface{}, out interface{}) error {
+	return c.Do(ctx, http.MethodPut, path, body, out)
+}
+
+// Patch sends body as JSON and decodes data into out
+func (c *Client) Patch(ctx context.Context, path string, body interface{}, out interface{}) error {
+	return c.Do(ctx, http.MethodPatch, path, body, out)
+}
+
+// Delete sends DELETE request and decodes data into out
+func (c *Client) Delete(ctx context.Context, path string, out interface{}) error {
+	return c.Do(ctx, http.MethodDelete, path, nil, out)
+}
+
+// Do sends request with body encoded as JSON and decodes data of JSONResponse into out, out may be nil.
+// Error of the response is returned as *core.APIError with HTTPStatus of the response when the server omits it.
+// Non-idempotent requests are retried only on 429 and 503 since the server did not process them.
+func (c *Client) Do(ctx context.Context, method string, path string, body interface{}, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("[apiclient] failed to encode request body due to error: %v", err)
+		}
+	}
+	methodType, _ := core.ParseRequestMethodType(method)
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retryAfter time.Duration
+		retryAfter, err = c.do(ctx, method, path, payload, out)
+		if err == nil || attempt >= c.MaxRetries || !c.shouldRetry(err, methodType.IsIdempotent()) {
+			return err
+		}
+
+		wait := c.backoff(attempt)
+		if retryAfter > 0 {
+			wait = retryAfter
+			if c.MaxBackoff > 0 && wait > c.MaxBackoff {
+				wait = c.MaxBackoff
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, payload []byte, out interface{}) (time.Duration, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	var reqBody io.Reader
+	if payload != nil {
+		reqBody = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, c.BaseURL+path, reqBody)
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	for key, values := range c.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Set("Accept", core.MediaTypeJSON)
+	if payload != nil {
+		req.Header.Set("Content-Type", core.MediaTypeJSON)
+	}
+	if c.RequestID != nil && c.RequestIDHeader != "" {
+		if requestID := c.RequestID(ctx); requestID != "" {
+			req.Header.Set(c.RequestIDHeader, requestID)
+		}
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	maxResponseSize := c.MaxResponseSize
+	if maxResponseSize <= 0 {
+		maxResponseSize = DefaultMaxResponseSize
+	}
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+	if err != nil {
+		return 0, err
+	}
+	if int64(len(respBody)) > maxResponseSize {
+		return 0, fmt.Errorf("[apiclient] response of %v %v exceeds %v bytes", method, path, maxResponseSize)
+	}
+	retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == core.ProblemJSONContentType {
+		var problem core.ProblemDocument
+		if err := json.Unmarshal(respBody, &problem); err != nil {
+			return retryAfter, core.NewAPIError(0, resp.StatusCode, http.StatusText(resp.StatusCode), err)
+		}
+		apiError := problem.APIError()
+		if apiError.HTTPStatus == 0 {
+			apiError.HTTPStatus = resp.StatusCode
+		}
+		return retryAfter, apiError
+	}
+
+	decoded, decodeErr := core.DecodeJSONResponse(respBody, out)
+	if resp.StatusCode >= 400 || (decodeErr == nil && !decoded.Success) {
+		apiError := decoded.Error
+		if decodeErr != nil || apiError == nil {
+			apiError = core.NewAPIError(0, resp.StatusCode, http.StatusText(resp.StatusCode), decodeErr)
+		}
+		if apiError.HTTPStatus == 0 {
+			apiError.HTTPStatus = resp.StatusCode
+		}
+		return retryAfter, apiError
+	}
+	if decodeErr != nil {
+		return 0, fmt.Errorf("[apiclient] failed to decode response of %v %v due to error: %v", method, path, decodeErr)
+	}
+	return 0, nil
+}
+
+func (c *Client) shouldRetry(err error, idempotent bool) bool {
+	apiError, ok := err.(*core.APIError)
+	if !ok {
+		// network errors, the request may have been processed
+		return idempotent
+	}
+	if idempotent {
+		return apiError.IsRetryable()
+	}
+	return apiError.HTTPStatus == http.StatusTooManyRequests || apiError.HTTPStatus == http.StatusServiceUnavailable
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := c.Backoff << uint(attempt)
+	if c.MaxBackoff > 0 && wait > c.MaxBackoff {
+		wait = c.MaxBackoff
+	}
+	return wait
+}
+
+// parseRetryAfter supports both seconds and http date forms
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
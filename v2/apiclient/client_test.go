@@ -0,0 +1,149 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	core "chanyut/core/v2"
+)
+
+func newTestClient(handler http.HandlerFunc) (*Client, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	client := New(server.URL)
+	client.Backoff = time.Millisecond
+	client.MaxBackoff = 5 * time.Millisecond
+	return client, server
+}
+
+func TestClientDecodesData(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get(core.RequestIDHeader) != "req-1" {
+			t.Errorf("request id header %q, want req-1", req.Header.Get(core.RequestIDHeader))
+		}
+		renderer := core.NewHTTPResultRenderer(w, req)
+		renderer.RenderJSONSuccess(map[string]string{"name": "chanyut"})
+	})
+	defer server.Close()
+
+	var out struct{ Name string }
+	ctx := core.ContextWithRequestID(context.Background(), "req-1")
+	if err := client.Get(ctx, "/users/1", &out); err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	if out.Name != "chanyut" {
+		t.Fatalf("name %q, want chanyut", out.Name)
+	}
+}
+
+func TestClientDecodesErrors(t *testing.T) {
+	fields := []core.FieldError{{Key: "username", Message: "is required", Code: "required"}}
+	cases := []struct {
+		name   string
+		accept string
+	}{
+		{"envelope", core.MediaTypeJSON},
+		{"problem", core.ProblemJSONContentType},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client, server := newTestClient(func(w http.ResponseWriter, req *http.Request) {
+				req.Header.Set("Accept", c.accept)
+				renderer := core.NewHTTPResultRenderer(w, req)
+				renderer.RenderValidationErrors(fields)
+			})
+			defer server.Close()
+
+			err := client.Post(context.Background(), "/users", map[string]string{}, nil)
+			var apiError *core.APIError
+			if !errors.As(err, &apiError) {
+				t.Fatalf("error %v, want *core.APIError", err)
+			}
+			if apiError.HTTPStatus != http.StatusBadRequest || apiError.Code != core.ErrorCodeValidationFailed.Code ||
+				apiError.ErrorID != core.ErrorCodeValidationFailed.ID {
+				t.Errorf("error %#v, want %v", apiError, core.ErrorCodeValidationFailed.Code)
+			}
+			if len(apiError.Fields) != 1 || apiError.Fields[0].Key != "username" {
+				t.Errorf("fields %#v, want username", apiError.Fields)
+			}
+		})
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	cases := []struct {
+		name         string
+		method       string
+		status       int
+		wantAttempts int32
+	}{
+		{"idempotent on 502", http.MethodGet, http.StatusBadGateway, 3},
+		{"non-idempotent on 502", http.MethodPost, http.StatusBadGateway, 1},
+		{"non-idempotent on 503", http.MethodPost, http.StatusServiceUnavailable, 3},
+		{"not retryable status", http.MethodGet, http.StatusNotFound, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var attempts int32
+			client, server := newTestClient(func(w http.ResponseWriter, req *http.Request) {
+				atomic.AddInt32(&attempts, 1)
+				renderer := core.NewHTTPResultRenderer(w, req)
+				renderer.RenderJSONError(core.NewAPIError(0, c.status, http.StatusText(c.status), nil))
+			})
+			defer server.Close()
+
+			err := client.Do(context.Background(), c.method, "/users", nil, nil)
+			var apiError *core.APIError
+			if !errors.As(err, &apiError) || apiError.HTTPStatus != c.status {
+				t.Fatalf("error %v, want status %v", err, c.status)
+			}
+			if attempts != c.wantAttempts {
+				t.Errorf("attempts %v, want %v", attempts, c.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestClientClampsRetryAfter(t *testing.T) {
+	var attempts int32
+	client, server := newTestClient(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		renderer := core.NewHTTPResultRenderer(w, req)
+		renderer.RenderJSONSuccess(nil)
+	})
+	defer server.Close()
+
+	start := time.Now()
+	if err := client.Get(context.Background(), "/users", nil); err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("retry took %v, Retry-After is expected to be capped by MaxBackoff", elapsed)
+	}
+	if attempts != 2 {
+		t.Fatalf("attempts %v, want 2", attempts)
+	}
+}
+
+func TestClientRejectsLargeResponse(t *testing.T) {
+	client, server := newTestClient(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", core.MediaTypeJSON)
+		w.Write([]byte(`{"success":true,"data":"` + strings.Repeat("a", 100) + `"}`))
+	})
+	defer server.Close()
+	client.MaxResponseSize = 64
+
+	err := client.Get(context.Background(), "/users", nil)
+	if err == nil || !strings.Contains(err.Error(), "exceeds 64 bytes") {
+		t.Fatalf("error %v, want response size error", err)
+	}
+}
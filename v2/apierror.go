@@ -1,21 +1,147 @@
 package core
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"gopkg.in/mgo.v2"
 )
 
 type APIError struct {
-	ErrorID              int    `json:"id"`
-	HTTPStatus           int    `json:"httpStatus"`
-	Message              string `json:"message"`
-	InternalErrorMessage string `json:"internalErrorMessage,omitempty"`
+	ErrorID              int          `json:"id"`
+	Code                 string       `json:"code,omitempty"`
+	HTTPStatus           int          `json:"httpStatus"`
+	Message              string       `json:"message"`
+	InternalErrorMessage string       `json:"internalErrorMessage,omitempty"`
+	CorrelationID        string       `json:"correlationId,omitempty"`
+	Fields               []FieldError `json:"fields,omitempty"`
+
+	// MessageKey is i18n key resolved into Message at render time, Message is used when translation is missing
+	MessageKey  string        `json:"-"`
+	MessageArgs []interface{} `json:"-"`
+
+	// Headers are set on the response when the error is rendered e.g. Retry-After, WWW-Authenticate
+	Headers http.Header `json:"-"`
+
+	cause error
+	stack []byte
 }
 
 func (err APIError) Error() string {
 	return fmt.Sprintf("[Err-%v] %v\n%v", err.ErrorID, err.Message, err.InternalErrorMessage)
 }
 
+// Unwrap returns the error which caused this APIError
+func (err APIError) Unwrap() error {
+	return err.cause
+}
+
+// Is reports whether err has the same id as target, target can be a registered ErrorCode or another APIError
+// e.g. errors.Is(err, ErrorCodeNotFound)
+func (err APIError) Is(target error) bool {
+	switch t := target.(type) {
+	case ErrorCode:
+		return err.ErrorID == t.ID
+	case *ErrorCode:
+		return t != nil && err.ErrorID == t.ID
+	case APIError:
+		return err.ErrorID == t.ErrorID
+	case *APIError:
+		return t != nil && err.ErrorID == t.ErrorID
+	}
+	return false
+}
+
+// WithHeader adds header which must be sent along with the error
+func (err *APIError) WithHeader(key, value string) *APIError {
+	if err.Headers == nil {
+		err.Headers = http.Header{}
+	}
+	err.Headers.Set(key, value)
+	return err
+}
+
+// WithRetryAfter sets Retry-After header in seconds
+func (err *APIError) WithRetryAfter(retryAfter time.Duration) *APIError {
+	seconds := int(retryAfter / time.Second)
+	if retryAfter%time.Second != 0 {
+		seconds++
+	}
+	return err.WithHeader("Retry-After", strconv.Itoa(seconds))
+}
+
+// WithWWWAuthenticate sets WWW-Authenticate header e.g. `Bearer realm="api"`
+func (err *APIError) WithWWWAuthenticate(challenge string) *APIError {
+	return err.WithHeader("WWW-Authenticate", challenge)
+}
+
+// IsClientError reports whether the error is 4xx
+func (err APIError) IsClientError() bool {
+	return err.HTTPStatus >= 400 && err.HTTPStatus < 500
+}
+
+// IsServerError reports whether the error is 5xx
+func (err APIError) IsServerError() bool {
+	return err.HTTPStatus >= 500 && err.HTTPStatus < 600
+}
+
+// IsRetryable reports whether the same request may succeed later
+func (err APIError) IsRetryable() bool {
+	switch err.HTTPStatus {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// IsRetryableError reports whether err is an APIError which is retryable
+func IsRetryableError(err error) bool {
+	var apiError *APIError
+	if errors.As(err, &apiError) {
+		return apiError.IsRetryable()
+	}
+	return false
+}
+
+// Stack returns stack trace captured when the error was created, it is captured only when internal errors are shown
+func (err APIError) Stack() string {
+	return string(err.stack)
+}
+
+// AsAPIError walks the chain of err and returns the first APIError found.
+// Well-known errors are mapped into registered codes: mgo.ErrNotFound => ErrorCodeNotFound,
+// duplicate key => ErrorCodeDuplicated, context.DeadlineExceeded => ErrorCodeTimeout.
+// Others become ErrorCodeUnspecified. It returns nil when err is nil.
+func AsAPIError(err error) *APIError {
+	if err == nil {
+		return nil
+	}
+	var apiError *APIError
+	if errors.As(err, &apiError) {
+		return apiError
+	}
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		switch {
+		case e == mgo.ErrNotFound:
+			return NewAPIErrorFromCode(ErrorCodeNotFound, err)
+		case mgo.IsDup(e):
+			return NewAPIErrorFromCode(ErrorCodeDuplicated, err)
+		case e == context.DeadlineExceeded:
+			return NewAPIErrorFromCode(ErrorCodeTimeout, err)
+		}
+	}
+	return NewAPIErrorFromCode(ErrorCodeUnspecified, err)
+}
+
 func NewAPIError(id int, httpStatus int, errMsg string, err error) *APIError {
 	apiError := &APIError{
 		ErrorID:    id,
@@ -24,71 +150,113 @@ func NewAPIError(id int, httpStatus int, errMsg string, err error) *APIError {
 	}
 	if err != nil {
 		apiError.InternalErrorMessage = err.Error()
+		apiError.cause = err
+	}
+	if !hideInternalErrors() {
+		apiError.stack = debug.Stack()
 	}
 	return apiError
 }
 
 // NewAPI404Error - Resource not found
 func NewAPI404Error(id int, errMsg string, err error) *APIError {
-	apiError := &APIError{
-		ErrorID:    id,
-		HTTPStatus: http.StatusNotFound,
-		Message:    errMsg,
-	}
-	if err != nil {
-		apiError.InternalErrorMessage = err.Error()
-	}
-	return apiError
+	return NewAPIError(id, http.StatusNotFound, errMsg, err)
 }
 
 // NewAPI400Error - Bad request error
 func NewAPI400Error(id int, errMsg string, err error) *APIError {
-	apiError := &APIError{
-		ErrorID:    id,
-		HTTPStatus: http.StatusBadRequest,
-		Message:    errMsg,
-	}
-	if err != nil {
-		apiError.InternalErrorMessage = err.Error()
-	}
-	return apiError
+	return NewAPIError(id, http.StatusBadRequest, errMsg, err)
 }
 
 // NewAPI401Error - Unauthorized error or Not authenticated
 func NewAPI401Error(id int, errMsg string, err error) *APIError {
-	apiError := &APIError{
-		ErrorID:    id,
-		HTTPStatus: http.StatusUnauthorized,
-		Message:    errMsg,
-	}
-	if err != nil {
-		apiError.InternalErrorMessage = err.Error()
-	}
-	return apiError
+	return NewAPIError(id, http.StatusUnauthorized, errMsg, err)
 }
 
 // NewAPI403Error - Forbidden, client identity is known by server, but he has no authorize to access the requested resources
 func NewAPI403Error(id int, errMsg string, err error) *APIError {
-	apiError := &APIError{
-		ErrorID:    id,
-		HTTPStatus: http.StatusForbidden,
-		Message:    errMsg,
-	}
-	if err != nil {
-		apiError.InternalErrorMessage = err.Error()
+	return NewAPIError(id, http.StatusForbidden, errMsg, err)
+}
+
+// NewAPI405Error - Method not allowed, use WithHeader("Allow", ...) to list allowed methods
+func NewAPI405Error(id int, errMsg string, err error) *APIError {
+	return NewAPIError(id, http.StatusMethodNotAllowed, errMsg, err)
+}
+
+// NewAPI406Error - Not acceptable, server cannot produce response matching Accept headers
+func NewAPI406Error(id int, errMsg string, err error) *APIError {
+	return NewAPIError(id, http.StatusNotAcceptable, errMsg, err)
+}
+
+// NewAPI408Error - Request timeout, server did not receive complete request in time
+func NewAPI408Error(id int, errMsg string, err error) *APIError {
+	return NewAPIError(id, http.StatusRequestTimeout, errMsg, err)
+}
+
+// NewAPI409Error - Conflict with current state of the resource
+func NewAPI409Error(id int, errMsg string, err error) *APIError {
+	return NewAPIError(id, http.StatusConflict, errMsg, err)
+}
+
+// NewAPI410Error - Gone, the resource is no longer available and will not be available again
+func NewAPI410Error(id int, errMsg string, err error) *APIError {
+	return NewAPIError(id, http.StatusGone, errMsg, err)
+}
+
+// NewAPI412Error - Precondition failed e.g. If-Match does not match
+func NewAPI412Error(id int, errMsg string, err error) *APIError {
+	return NewAPIError(id, http.StatusPreconditionFailed, errMsg, err)
+}
+
+// NewAPI413Error - Request body is larger than server is willing to process
+func NewAPI413Error(id int, errMsg string, err error) *APIError {
+	return NewAPIError(id, http.StatusRequestEntityTooLarge, errMsg, err)
+}
+
+// NewAPI415Error - Unsupported media type of request body
+func NewAPI415Error(id int, errMsg string, err error) *APIError {
+	return NewAPIError(id, http.StatusUnsupportedMediaType, errMsg, err)
+}
+
+// NewAPI422Error - Request is well-formed but cannot be processed
+func NewAPI422Error(id int, errMsg string, err error) *APIError {
+	return NewAPIError(id, http.StatusUnprocessableEntity, errMsg, err)
+}
+
+// NewAPI429Error - Too many requests, retryAfter is sent as Retry-After header when it is not zero
+func NewAPI429Error(id int, errMsg string, retryAfter time.Duration, err error) *APIError {
+	apiError := NewAPIError(id, http.StatusTooManyRequests, errMsg, err)
+	if retryAfter > 0 {
+		apiError.WithRetryAfter(retryAfter)
 	}
 	return apiError
 }
 
 // NewAPI500Error - The server has encountered a situation it doesn't know how to handle.
 func NewAPI500Error(id int, errMsg string, err error) *APIError {
-	apiError := &APIError{
-		ErrorID:    id,
-		HTTPStatus: http.StatusInternalServerError,
-		Message:    errMsg,
-	}
-	if err != nil {
-		apiError.InternalErrorMessage = err.Error()
+	return NewAPIError(id, http.StatusInternalServerError, errMsg, err)
+}
+
+// NewAPI501Error - The request method is not supported by the server
+func NewAPI501Error(id int, errMsg string, err error) *APIError {
+	return NewAPIError(id, http.StatusNotImplemented, errMsg, err)
+}
+
+// NewAPI502Error - Bad gateway, upstream server returned an invalid response
+func NewAPI502Error(id int, errMsg string, err error) *APIError {
+	return NewAPIError(id, http.StatusBadGateway, errMsg, err)
+}
+
+// NewAPI503Error - Service unavailable, retryAfter is sent as Retry-After header when it is not zero
+func NewAPI503Error(id int, errMsg string, retryAfter time.Duration, err error) *APIError {
+	apiError := NewAPIError(id, http.StatusServiceUnavailable, errMsg, err)
+	if retryAfter > 0 {
+		apiError.WithRetryAfter(retryAfter)
 	}
 	return apiError
 }
+
+// NewAPI504Error - Gateway timeout, upstream server did not respond in time
+func NewAPI504Error(id int, errMsg string, err error) *APIError {
+	return NewAPIError(id, http.StatusGatewayTimeout, errMsg, err)
+}
@@ -0,0 +1,347 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// AuditLogCollectionName is the collection which audit trails are written into
+const AuditLogCollectionName = "audit_log"
+
+// AuditMaskedValue replaces values of masked fields in audit log
+const AuditMaskedValue = "********"
+
+// AuditAction is type of write operation recorded in audit log
+type AuditAction string
+
+const (
+	AuditActionInsert AuditAction = "insert"
+	AuditActionUpdate AuditAction = "update"
+	AuditActionDelete AuditAction = "delete"
+)
+
+// AuditContext holds information of who is performing writes through MgoDb
+type AuditContext struct {
+	Actor     string
+	RequestID string
+}
+
+// AuditFieldChange holds value of a field before and after the write, nested fields are dotted e.g. "profile.name"
+type AuditFieldChange struct {
+	Field  string      `bson:"field" json:"field"`
+	Before interface{} `bson:"before,omitempty" json:"before,omitempty"`
+	After  interface{} `bson:"after,omitempty" json:"after,omitempty"`
+}
+
+// AuditLog is a document stored in audit_log collection
+type AuditLog struct {
+	ID         bson.ObjectId      `bson:"_id" json:"id"`
+	Action     AuditAction        `bson:"action" json:"action"`
+	Actor      string             `bson:"actor" json:"actor"`
+	Collection string             `bson:"collection" json:"collection"`
+	DocumentID interface{}        `bson:"documentId" json:"documentId"`
+	Changes    []AuditFieldChange `bson:"changes" json:"changes"`
+	RequestID  string             `bson:"requestId,omitempty" json:"requestId,omitempty"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// AuditLogQuery is filter for FindAuditLogs, empty fields are ignored
+type AuditLogQuery struct {
+	MgoDBQuery
+	Actor      string      `json:"actor"`
+	Collection string      `json:"collection"`
+	DocumentID interface{} `json:"documentId"`
+	RequestID  string      `json:"requestId"`
+	From       time.Time   `json:"from"`
+	To         time.Time   `json:"to"`
+}
+
+var (
+	auditMaskedFieldsLock sync.RWMutex
+	auditMaskedFields     = map[string]bool{
+		"password":       true,
+		"hashedpassword": true,
+		"passwordhash":   true,
+		"secret":         true,
+		"secretkey":      true,
+		"token":          true,
+	}
+)
+
+// RegisterAuditMaskedFields adds field names whose values are masked in audit log.
+// Field name is matched case-insensitively against the last segment of the field path.
+func RegisterAuditMaskedFields(fields ...string) {
+	auditMaskedFieldsLock.Lock()
+	defer auditMaskedFieldsLock.Unlock()
+	for _, field := range fields {
+		auditMaskedFields[strings.ToLower(field)] = true
+	}
+}
+
+// EnableAudit turns on audit trail for writes performed by Insert, UpdateID and RemoveID
+func (mgoDb *MgoDb) EnableAudit(actor string, requestID string) {
+	mgoDb.audit = &AuditContext{
+		Actor:     actor,
+		RequestID: requestID,
+	}
+}
+
+// DisableAudit turns off audit trail
+func (mgoDb *MgoDb) DisableAudit() {
+	mgoDb.audit = nil
+}
+
+// EnsureAuditLogIndexes creates indexes used by FindAuditLogs
+func (mgoDb *MgoDb) EnsureAuditLogIndexes() error {
+	indexes := [][]string{
+		{"collection", "documentId", "-createdAt"},
+		{"actor", "-createdAt"},
+		{"requestId"},
+	}
+	for _, keys := range indexes {
+		err := mgoDb.C(AuditLogCollectionName).EnsureIndex(mgo.Index{Key: keys, Background: true})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindAuditLogs returns audit logs matched the query, newest first
+func (mgoDb *MgoDb) FindAuditLogs(q AuditLogQuery) ([]AuditLog, error) {
+	query := bson.M{}
+	if q.Actor != "" {
+		query["actor"] = q.Actor
+	}
+	if q.Collection != "" {
+		query["collection"] = q.Collection
+	}
+	if q.DocumentID != nil {
+		query["documentId"] = q.DocumentID
+	}
+	if q.RequestID != "" {
+		query["requestId"] = q.RequestID
+	}
+	createdAt := bson.M{}
+	if !q.From.IsZero() {
+		createdAt["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		createdAt["$lt"] = q.To
+	}
+	if len(createdAt) > 0 {
+		query["createdAt"] = createdAt
+	}
+
+	logs := []AuditLog{}
+	err := mgoDb.C(AuditLogCollectionName).Find(query).Sort("-createdAt").Skip(q.Offset).Limit(q.Limit).All(&logs)
+	return logs, err
+}
+
+func (mgoDb *MgoDb) insertWithAudit(collection string, docs ...interface{}) error {
+	records := make([]bson.M, 0, len(docs))
+	inserts := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		record, err := toBsonM(doc)
+		if err != nil {
+			return fmt.Errorf("[MgoDb::Insert] failed to convert document due to error: %v", err)
+		}
+		if _, ok := record["_id"]; !ok {
+			// the generated id is written back so the caller can refer to the inserted document
+			id := bson.NewObjectId()
+			if !setDocumentID(doc, id) {
+				return fmt.Errorf("[MgoDb::Insert] _id of %T cannot be set, pass a pointer or set _id before inserting", doc)
+			}
+			record["_id"] = id
+		}
+		records = append(records, record)
+		inserts = append(inserts, record)
+	}
+
+	if err := mgoDb.C(collection).Insert(inserts...); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := mgoDb.writeAuditLog(AuditActionInsert, collection, record["_id"], nil, record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateWithAudit updates the first document of col matched query, it returns mgo.ErrNotFound when nothing matches
+func (mgoDb *MgoDb) updateWithAudit(col *mgo.Collection, query bson.M, update interface{}) error {
+	before := bson.M{}
+	if err := col.Find(query).One(&before); err != nil {
+		return err
+	}
+	id := before["_id"]
+	if err := col.Update(query, update); err != nil {
+		return err
+	}
+	after := bson.M{}
+	if err := col.FindId(id).One(&after); err != nil {
+		return err
+	}
+	return mgoDb.writeAuditLog(AuditActionUpdate, col.Name, id, before, after)
+}
+
+func (mgoDb *MgoDb) removeWithAudit(collection string, id interface{}) error {
+	before := bson.M{}
+	if err := mgoDb.C(collection).FindId(id).One(&before); err != nil {
+		return err
+	}
+	if err := mgoDb.C(collection).RemoveId(id); err != nil {
+		return err
+	}
+	return mgoDb.writeAuditLog(AuditActionDelete, collection, id, before, nil)
+}
+
+func (mgoDb *MgoDb) writeAuditLog(action AuditAction, collection string, id interface{}, before, after bson.M) error {
+	auditLog := AuditLog{
+		ID:         bson.NewObjectId(),
+		Action:     action,
+		Actor:      mgoDb.audit.Actor,
+		Collection: collection,
+		DocumentID: id,
+		Changes:    diffAuditFields(before, after),
+		RequestID:  mgoDb.auditRequestID(),
+		CreatedAt:  time.Now(),
+	}
+	// audit log is written through Db directly, so Col of the caller is left untouched
+	err := mgoDb.Db.C(AuditLogCollectionName).Insert(auditLog)
+	if err != nil {
+		return fmt.Errorf("[MgoDb::Audit] failed to write audit log of %v[%v] due to error: %v", collection, id, err)
+	}
+	return nil
+}
+
+func (mgoDb *MgoDb) auditRequestID() string {
+	if mgoDb.audit.RequestID != "" {
+		return mgoDb.audit.RequestID
+	}
+	return mgoDb.RequestID
+}
+
+func toBsonM(doc interface{}) (bson.M, error) {
+	if m, ok := doc.(bson.M); ok {
+		return m, nil
+	}
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	m := bson.M{}
+	err = bson.Unmarshal(data, &m)
+	return m, err
+}
+
+// setDocumentID sets id as _id of doc which is a map or a pointer to struct with `bson:"_id"` field,
+// it returns false when doc has no settable _id
+func setDocumentID(doc interface{}, id bson.ObjectId) bool {
+	value := reflect.ValueOf(doc)
+	if value.Kind() == reflect.Map && value.Type().Key().Kind() == reflect.String && !value.IsNil() {
+		idValue := reflect.ValueOf(id)
+		if !idValue.Type().AssignableTo(value.Type().Elem()) {
+			return false
+		}
+		value.SetMapIndex(reflect.ValueOf("_id").Convert(value.Type().Key()), idValue)
+		return true
+	}
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return false
+	}
+	value = value.Elem()
+	for i := 0; i < value.NumField(); i++ {
+		tag := value.Type().Field(i).Tag.Get("bson")
+		if name := strings.Split(tag, ",")[0]; name != "_id" {
+			continue
+		}
+		field := value.Field(i)
+		if !field.CanSet() || !reflect.TypeOf(id).AssignableTo(field.Type()) {
+			return false
+		}
+		field.Set(reflect.ValueOf(id))
+		return true
+	}
+	return false
+}
+
+// diffAuditFields returns changed fields between before and after sorted by field name
+func diffAuditFields(before, after bson.M) []AuditFieldChange {
+	beforeFields := map[string]interface{}{}
+	afterFields := map[string]interface{}{}
+	flattenAuditFields("", before, beforeFields)
+	flattenAuditFields("", after, afterFields)
+
+	fieldNames := []string{}
+	for field := range beforeFields {
+		fieldNames = append(fieldNames, field)
+	}
+	for field := range afterFields {
+		if _, ok := beforeFields[field]; !ok {
+			fieldNames = append(fieldNames, field)
+		}
+	}
+	sort.Strings(fieldNames)
+
+	changes := []AuditFieldChange{}
+	for _, field := range fieldNames {
+		b, a := beforeFields[field], afterFields[field]
+		if reflect.DeepEqual(b, a) {
+			continue
+		}
+		if isAuditMaskedField(field, b) || isAuditMaskedField(field, a) {
+			if b != nil {
+				b = AuditMaskedValue
+			}
+			if a != nil {
+				a = AuditMaskedValue
+			}
+		}
+		changes = append(changes, AuditFieldChange{Field: field, Before: b, After: a})
+	}
+	return changes
+}
+
+func flattenAuditFields(prefix string, doc bson.M, out map[string]interface{}) {
+	for key, value := range doc {
+		field := key
+		if prefix != "" {
+			field = prefix + "." + key
+		}
+		if nested, ok := value.(bson.M); ok && len(nested) > 0 {
+			flattenAuditFields(field, nested, out)
+			continue
+		}
+		out[field] = value
+	}
+}
+
+func isAuditMaskedField(field string, value interface{}) bool {
+	// values produced by GeneratePassword are always masked regardless of field name
+	if s, ok := value.(string); ok && isBcryptHash(s) {
+		return true
+	}
+	name := field
+	if i := strings.LastIndex(field, "."); i >= 0 {
+		name = field[i+1:]
+	}
+	auditMaskedFieldsLock.RLock()
+	defer auditMaskedFieldsLock.RUnlock()
+	return auditMaskedFields[strings.ToLower(name)]
+}
+
+func isBcryptHash(s string) bool {
+	if len(s) != 60 {
+		return false
+	}
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$")
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestFlattenAuditFields(t *testing.T) {
+	cases := []struct {
+		name string
+		doc  bson.M
+		want map[string]interface{}
+	}{
+		{"empty", bson.M{}, map[string]interface{}{}},
+		{"flat", bson.M{"name": "a", "age": 1}, map[string]interface{}{"name": "a", "age": 1}},
+		{"nested", bson.M{"profile": bson.M{"name": "a", "address": bson.M{"city": "b"}}}, map[string]interface{}{
+			"profile.name":         "a",
+			"profile.address.city": "b",
+		}},
+		{"empty nested is kept", bson.M{"profile": bson.M{}}, map[string]interface{}{"profile": bson.M{}}},
+		{"slice is kept", bson.M{"tags": []interface{}{"a"}}, map[string]interface{}{"tags": []interface{}{"a"}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := map[string]interface{}{}
+			flattenAuditFields("", c.doc, got)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("flattenAuditFields() = %#v, want %#v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestIsAuditMaskedField(t *testing.T) {
+	hash := "$2a$10$" + strings.Repeat("a", 53)
+	cases := []struct {
+		field string
+		value interface{}
+		want  bool
+	}{
+		{"password", "secret", true},
+		{"Password", "secret", true},
+		{"profile.token", "abc", true},
+		{"token.owner", "abc", false},
+		{"name", "chanyut", false},
+		{"name", hash, true},
+		{"name", hash[:59], false},
+		{"name", 1, false},
+	}
+	for _, c := range cases {
+		t.Run(c.field, func(t *testing.T) {
+			if got := isAuditMaskedField(c.field, c.value); got != c.want {
+				t.Fatalf("isAuditMaskedField(%q, %v) = %v, want %v", c.field, c.value, got, c.want)
+			}
+		})
+	}
+}
+
+func TestDiffAuditFields(t *testing.T) {
+	cases := []struct {
+		name   string
+		before bson.M
+		after  bson.M
+		want   []AuditFieldChange
+	}{
+		{
+			name:  "insert",
+			after: bson.M{"_id": 1, "name": "a"},
+			want:  []AuditFieldChange{{Field: "_id", After: 1}, {Field: "name", After: "a"}},
+		},
+		{
+			name:   "delete",
+			before: bson.M{"name": "a"},
+			want:   []AuditFieldChange{{Field: "name", Before: "a"}},
+		},
+		{
+			name:   "unchanged fields are skipped",
+			before: bson.M{"_id": 1, "name": "a", "age": 1},
+			after:  bson.M{"_id": 1, "name": "b", "age": 1},
+			want:   []AuditFieldChange{{Field: "name", Before: "a", After: "b"}},
+		},
+		{
+			name:   "nested fields are dotted",
+			before: bson.M{"profile": bson.M{"city": "a", "zip": "1"}},
+			after:  bson.M{"profile": bson.M{"city": "b", "zip": "1"}},
+			want:   []AuditFieldChange{{Field: "profile.city", Before: "a", After: "b"}},
+		},
+		{
+			name:   "masked by name",
+			before: bson.M{"password": "old"},
+			after:  bson.M{"password": "new"},
+			want:   []AuditFieldChange{{Field: "password", Before: AuditMaskedValue, After: AuditMaskedValue}},
+		},
+		{
+			name:  "added masked field keeps nil before",
+			after: bson.M{"profile": bson.M{"secret": "s"}},
+			want:  []AuditFieldChange{{Field: "profile.secret", After: AuditMaskedValue}},
+		},
+		{
+			name:   "masked by bcrypt value",
+			before: bson.M{"credential": nil},
+			after:  bson.M{"credential": "$2b$10$" + strings.Repeat("a", 53)},
+			want:   []AuditFieldChange{{Field: "credential", After: AuditMaskedValue}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := diffAuditFields(c.before, c.after); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("diffAuditFields() = %#v, want %#v", got, c.want)
+			}
+		})
+	}
+}
+
+type auditTestDocument struct {
+	ID   bson.ObjectId `bson:"_id,omitempty"`
+	Name string        `bson:"name"`
+}
+
+func TestSetDocumentID(t *testing.T) {
+	id := bson.NewObjectId()
+	cases := []struct {
+		name   string
+		doc    interface{}
+		want   bool
+		wantID func(doc interface{}) interface{}
+	}{
+		{"pointer to struct", &auditTestDocument{Name: "a"}, true, func(doc interface{}) interface{} { return doc.(*auditTestDocument).ID }},
+		{"bson.M", bson.M{"name": "a"}, true, func(doc interface{}) interface{} { return doc.(bson.M)["_id"] }},
+		{"map", map[string]interface{}{"name": "a"}, true, func(doc interface{}) interface{} { return doc.(map[string]interface{})["_id"] }},
+		{"struct value", auditTestDocument{Name: "a"}, false, nil},
+		{"struct without _id", &struct{ Name string }{Name: "a"}, false, nil},
+		{"_id of other type", &struct {
+			ID string `bson:"_id"`
+		}{}, false, nil},
+		{"map of other values", map[string]string{"name": "a"}, false, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := setDocumentID(c.doc, id); got != c.want {
+				t.Fatalf("setDocumentID() = %v, want %v", got, c.want)
+			}
+			if c.wantID != nil && c.wantID(c.doc) != id {
+				t.Fatalf("_id %v, want %v", c.wantID(c.doc), id)
+			}
+		})
+	}
+}
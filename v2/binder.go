@@ -0,0 +1,209 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"strings"
+
+	"github.com/revel/revel"
+)
+
+// DefaultMaxBodySize is used when BindOptions.MaxBodySize is zero
+const DefaultMaxBodySize int64 = 1 << 20
+
+// bodyTooLargeArgKey is key of controller Args which MaxBodySizeFilter sets to its limit when it cuts the body
+const bodyTooLargeArgKey = "bodyTooLarge"
+
+var errBodyTooLarge = errors.New("request body too large")
+
+var (
+	ErrorCodeMalformedBody = RegisterErrorCode(ErrorCode{
+		ID:         6,
+		Code:       "malformed_body",
+		HTTPStatus: 400,
+		Message:    "Malformed request body: %v",
+		MessageKey: "core.error.malformed_body",
+	})
+	ErrorCodeBodyTooLarge = RegisterErrorCode(ErrorCode{
+		ID:         7,
+		Code:       "body_too_large",
+		HTTPStatus: 413,
+		Message:    "Request body must not be larger than %v bytes",
+		MessageKey: "core.error.body_too_large",
+	})
+	ErrorCodeUnsupportedMediaType = RegisterErrorCode(ErrorCode{
+		ID:         8,
+		Code:       "unsupported_media_type",
+		HTTPStatus: 415,
+		Message:    "Content-Type must be application/json but got %q",
+		MessageKey: "core.error.unsupported_media_type",
+	})
+)
+
+// BindOptions configures decoding of JSON request body
+type BindOptions struct {
+	// MaxBodySize in bytes, DefaultMaxBodySize is used when it is zero. On revel the body of JSON requests
+	// is read into memory by revel.ParamsFilter before the action runs, use MaxBodySizeFilter to limit it earlier.
+	MaxBodySize int64
+	// DisallowUnknownFields rejects body which has fields not in the target struct
+	DisallowUnknownFields bool
+	// SkipValidation skips ValidateStruct after decoding
+	SkipValidation bool
+}
+
+// BindJSON decodes JSON body of current request into out then validates it with ValidateStruct.
+// The returned APIError is ready to be rendered with RenderJSONError.
+func (r *RevelResultRenderer) BindJSON(out interface{}, opts BindOptions) *APIError {
+	if limit, ok := r.controller.Args[bodyTooLargeArgKey].(int64); ok {
+		return NewAPIErrorFromCode(ErrorCodeBodyTooLarge, nil, limit)
+	}
+	req := r.controller.Request
+	var body io.Reader
+	if params := r.controller.Params; params != nil && params.JSON != nil {
+		// revel has already read the body of json requests
+		body = bytes.NewReader(params.JSON)
+	} else if req.In != nil {
+		body = req.GetBody()
+	}
+	return DecodeJSONBody(req.ContentType, body, out, opts)
+}
+
+// MaxBodySizeFilter limits request body to "api.max_body_size" bytes (default DefaultMaxBodySize) before
+// revel.ParamsFilter reads it into memory, so it must be placed before revel.ParamsFilter. Requests which
+// declare larger Content-Length are rejected with 413, bodies which turn out larger are cut and BindJSON returns 413.
+func MaxBodySizeFilter(c *revel.Controller, fc []revel.Filter) {
+	maxBodySize := configuredMaxBodySize()
+	goRequest, ok := c.Request.In.(*revel.GoRequest)
+	if !ok || goRequest.Original.Body == nil {
+		fc[0](c, fc[1:])
+		return
+	}
+
+	if goRequest.Original.ContentLength > maxBodySize {
+		renderer := NewRevelResultRenderer(c)
+		c.Result = renderer.RenderJSONError(NewAPIErrorFromCode(ErrorCodeBodyTooLarge, nil, maxBodySize))
+		return
+	}
+	goRequest.Original.Body = &maxBytesBody{
+		ReadCloser: goRequest.Original.Body,
+		remaining:  maxBodySize,
+		onExceeded: func() { c.Args[bodyTooLargeArgKey] = maxBodySize },
+	}
+	fc[0](c, fc[1:])
+}
+
+// configuredMaxBodySize returns "api.max_body_size", default is DefaultMaxBodySize
+func configuredMaxBodySize() int64 {
+	return int64(configInt("api.max_body_size", int(DefaultMaxBodySize)))
+}
+
+// maxBytesBody fails reads after remaining bytes, unlike http.MaxBytesReader it reports the cut through onExceeded
+type maxBytesBody struct {
+	io.ReadCloser
+	remaining  int64
+	exceeded   bool
+	onExceeded func()
+}
+
+func (b *maxBytesBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.remaining = 0
+		b.exceeded = true
+		b.onExceeded()
+		return n, errBodyTooLarge
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// DecodeJSONBody decodes body of contentType into out then validates it with ValidateStruct
+func DecodeJSONBody(contentType string, body io.Reader, out interface{}, opts BindOptions) *APIError {
+	if !isJSONContentType(contentType) {
+		return NewAPIErrorFromCode(ErrorCodeUnsupportedMediaType, nil, contentType)
+	}
+
+	maxBodySize := opts.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+	if body == nil {
+		body = bytes.NewReader(nil)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxBodySize+1))
+	if err != nil {
+		return NewAPIErrorFromCode(ErrorCodeMalformedBody, err, "failed to read body")
+	}
+	if int64(len(data)) > maxBodySize {
+		return NewAPIErrorFromCode(ErrorCodeBodyTooLarge, nil, maxBodySize)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if opts.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(out); err != nil {
+		return newDecodeAPIError(err)
+	}
+	if decoder.More() {
+		return NewAPIErrorFromCode(ErrorCodeMalformedBody, nil, "body must contain a single JSON value")
+	}
+
+	if !opts.SkipValidation {
+		fields, err := ValidateStruct(out)
+		if err != nil {
+			return NewAPIErrorFromCode(ErrorCodeUnspecified, err)
+		}
+		if fields != nil {
+			return NewValidationAPIError(fields)
+		}
+	}
+	return nil
+}
+
+// newDecodeAPIError describes decode error with position or field path of the problem
+func newDecodeAPIError(err error) *APIError {
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return NewAPIErrorFromCode(ErrorCodeMalformedBody, err, "body must not be empty")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return NewAPIErrorFromCode(ErrorCodeMalformedBody, err, "body is not complete JSON")
+	case errors.As(err, &syntaxError):
+		return NewAPIErrorFromCode(ErrorCodeMalformedBody, err, fmt.Sprintf("invalid JSON at offset %d", syntaxError.Offset))
+	case errors.As(err, &typeError):
+		detail := fmt.Sprintf("field %q must be %v but got %v", typeError.Field, typeError.Type, typeError.Value)
+		apiError := NewAPIErrorFromCode(ErrorCodeMalformedBody, err, detail)
+		apiError.Fields = []FieldError{
+			NewFieldError(typeError.Field, fmt.Sprintf("must be %v", typeError.Type), "type", nil),
+		}
+		return apiError
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		apiError := NewAPIErrorFromCode(ErrorCodeMalformedBody, err, fmt.Sprintf("unknown field %q", field))
+		apiError.Fields = []FieldError{NewFieldError(field, "is not allowed", "unknown", nil)}
+		return apiError
+	}
+	return NewAPIErrorFromCode(ErrorCodeMalformedBody, err, err.Error())
+}
+
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,193 @@
+package core
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/revel/revel"
+)
+
+func newBinderTestController(body string, contentLength int64) *revel.Controller {
+	r := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+	r.Header.Set("Content-Type", MediaTypeJSON)
+	r.ContentLength = contentLength
+	return newTestController(r, nil)
+}
+
+func TestMaxBodySizeFilter(t *testing.T) {
+	large := `{"name":"` + strings.Repeat("a", int(DefaultMaxBodySize)) + `"}`
+	cases := []struct {
+		name          string
+		body          string
+		contentLength int64
+		reachesAction bool
+		wantStatus    int
+	}{
+		{"small body", `{"name":"chanyut"}`, -1, true, 0},
+		{"declared length too large", large, int64(len(large)), false, http.StatusRequestEntityTooLarge},
+		{"chunked body too large", large, -1, true, http.StatusRequestEntityTooLarge},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			controller := newBinderTestController(c.body, c.contentLength)
+			reached := false
+			var bindErr *APIError
+			MaxBodySizeFilter(controller, []revel.Filter{func(controller *revel.Controller, fc []revel.Filter) {
+				reached = true
+				// reads the body like revel.ParamsFilter does for JSON requests
+				data, _ := ioutil.ReadAll(controller.Request.GetBody())
+				controller.Params = &revel.Params{JSON: data}
+				renderer := NewRevelResultRenderer(controller)
+				var out struct {
+					Name string `json:"name"`
+				}
+				bindErr = renderer.BindJSON(&out, BindOptions{MaxBodySize: 10 * DefaultMaxBodySize})
+			}})
+
+			if reached != c.reachesAction {
+				t.Fatalf("reached action %v, want %v", reached, c.reachesAction)
+			}
+			status := 0
+			if bindErr != nil {
+				status = bindErr.HTTPStatus
+			} else if controller.Result != nil {
+				status = controller.Response.Status
+			}
+			if status != c.wantStatus {
+				t.Fatalf("status %v, want %v", status, c.wantStatus)
+			}
+		})
+	}
+}
+
+type binderTestUser struct {
+	Name    string `json:"name" validate:"required"`
+	Age     int    `json:"age"`
+	Address struct {
+		City string `json:"city"`
+	} `json:"address"`
+}
+
+func TestDecodeJSONBody(t *testing.T) {
+	cases := []struct {
+		name        string
+		contentType string
+		body        string
+		opts        BindOptions
+		wantCode    *ErrorCode
+		wantMessage string
+		wantFields  []FieldError
+	}{
+		{
+			name:        "valid",
+			contentType: MediaTypeJSON + "; charset=utf-8",
+			body:        `{"name":"chanyut","age":30}`,
+		},
+		{
+			name:        "non-JSON content type",
+			contentType: "text/plain",
+			body:        `{"name":"chanyut"}`,
+			wantCode:    &ErrorCodeUnsupportedMediaType,
+			wantMessage: `"text/plain"`,
+		},
+		{
+			name:        "empty body",
+			contentType: MediaTypeJSON,
+			wantCode:    &ErrorCodeMalformedBody,
+			wantMessage: "body must not be empty",
+		},
+		{
+			name:        "truncated JSON",
+			contentType: MediaTypeJSON,
+			body:        `{"name":"chanyut"`,
+			wantCode:    &ErrorCodeMalformedBody,
+			wantMessage: "body is not complete JSON",
+		},
+		{
+			name:        "syntax error offset",
+			contentType: MediaTypeJSON,
+			body:        `{"name":"chanyut",}`,
+			wantCode:    &ErrorCodeMalformedBody,
+			wantMessage: "invalid JSON at offset 19",
+		},
+		{
+			name:        "type error of nested field",
+			contentType: MediaTypeJSON,
+			body:        `{"name":"chanyut","address":{"city":1}}`,
+			wantCode:    &ErrorCodeMalformedBody,
+			wantMessage: `field "address.city" must be string`,
+			wantFields:  []FieldError{{Key: "address.city", Message: "must be string", Code: "type"}},
+		},
+		{
+			name:        "unknown field is ignored by default",
+			contentType: MediaTypeJSON,
+			body:        `{"name":"chanyut","role":"admin"}`,
+		},
+		{
+			name:        "unknown field is disallowed",
+			contentType: MediaTypeJSON,
+			body:        `{"name":"chanyut","role":"admin"}`,
+			opts:        BindOptions{DisallowUnknownFields: true},
+			wantCode:    &ErrorCodeMalformedBody,
+			wantMessage: `unknown field "role"`,
+			wantFields:  []FieldError{{Key: "role", Message: "is not allowed", Code: "unknown"}},
+		},
+		{
+			name:        "trailing value",
+			contentType: MediaTypeJSON,
+			body:        `{"name":"chanyut"} {"name":"other"}`,
+			wantCode:    &ErrorCodeMalformedBody,
+			wantMessage: "body must contain a single JSON value",
+		},
+		{
+			name:        "body too large",
+			contentType: MediaTypeJSON,
+			body:        `{"name":"chanyut"}`,
+			opts:        BindOptions{MaxBodySize: 8},
+			wantCode:    &ErrorCodeBodyTooLarge,
+			wantMessage: "8 bytes",
+		},
+		{
+			name:        "validation",
+			contentType: MediaTypeJSON,
+			body:        `{"age":30}`,
+			wantCode:    &ErrorCodeValidationFailed,
+			wantFields:  []FieldError{{Key: "name", Message: "is required", Code: "required"}},
+		},
+		{
+			name:        "validation is skipped",
+			contentType: MediaTypeJSON,
+			body:        `{"age":30}`,
+			opts:        BindOptions{SkipValidation: true},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var user binderTestUser
+			err := DecodeJSONBody(c.contentType, strings.NewReader(c.body), &user, c.opts)
+			if c.wantCode == nil {
+				if err != nil {
+					t.Fatalf("DecodeJSONBody() error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("DecodeJSONBody() error is nil, want %v", c.wantCode.Code)
+			}
+			if err.Code != c.wantCode.Code || err.ErrorID != c.wantCode.ID || err.HTTPStatus != c.wantCode.HTTPStatus {
+				t.Errorf("error %v %v %v, want %v %v %v", err.ErrorID, err.Code, err.HTTPStatus, c.wantCode.ID, c.wantCode.Code, c.wantCode.HTTPStatus)
+			}
+			if !strings.Contains(err.Message, c.wantMessage) {
+				t.Errorf("message %q, want it to contain %q", err.Message, c.wantMessage)
+			}
+			if !reflect.DeepEqual(err.Fields, c.wantFields) {
+				t.Errorf("fields %#v, want %#v", err.Fields, c.wantFields)
+			}
+		})
+	}
+}
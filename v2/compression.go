@@ -0,0 +1,207 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultCompressionThreshold is minimum body size in bytes to be compressed
+const DefaultCompressionThreshold = 1024
+
+// finalizeResponse is applied when a RenderedResponse is written. It sets ETag header of the response,
+// answers 304 when If-None-Match matches, then compresses body with encoding negotiated
+// from Accept-Encoding.
+// ETag is enabled by "api.etag.enabled" (default true). Compression is enabled by "api.compression.enabled"
+// (default false) for bodies of at least "api.compression.threshold" bytes, revel.CompressFilter leaves
+// responses compressed here untouched.
+func finalizeResponse(req RenderRequest, resp RenderedResponse) RenderedResponse {
+	if resp.Header == nil {
+		resp.Header = http.Header{}
+	}
+
+	if configBool("api.etag.enabled", true) && resp.ETag != "" && resp.Status >= 200 && resp.Status < 300 {
+		resp.Header.Set("ETag", resp.ETag)
+		// the tag is of the representation negotiated from these request headers
+		resp.Header.Add("Vary", "Accept, Accept-Encoding, "+APIEnvelopeHeader)
+		if resp.Status == http.StatusOK && etagMatches(req.Header("If-None-Match"), resp.ETag) {
+			resp.Status = http.StatusNotModified
+			resp.Body = nil
+			return resp
+		}
+	}
+
+	if !configBool("api.compression.enabled", false) ||
+		len(resp.Body) < configInt("api.compression.threshold", DefaultCompressionThreshold) {
+		return resp
+	}
+	if !headerListContains(resp.Header, "Vary", "Accept-Encoding") {
+		resp.Header.Add("Vary", "Accept-Encoding")
+	}
+	encoding := negotiateEncoding(req.Header("Accept-Encoding"))
+	if encoding == "" {
+		return resp
+	}
+	body, err := compressBody(encoding, resp.Body)
+	if err != nil {
+		logf(req.RequestID(), "[Renderer] failed to compress response with %v due to error: %v", encoding, err)
+		return resp
+	}
+	resp.Header.Set("Content-Encoding", encoding)
+	resp.Body = body
+	return resp
+}
+
+// resourceETag returns weak entity tag of rendered resp. The tag is hashed from the body actually written,
+// so it differs per media type and envelope version, with requestId field of the envelope cut out so it
+// does not differ per request. It returns empty string when the field cannot be located.
+func resourceETag(resp RenderedResponse, requestID string) string {
+	mediaType, _, err := mime.ParseMediaType(resp.ContentType)
+	if err != nil {
+		return ""
+	}
+	body := resp.Body
+	if requestID != "" {
+		field, err := encodedRequestIDField(mediaType, requestID)
+		if err != nil {
+			return ""
+		}
+		// data may contain the same field, the envelope field follows data in every format
+		i := bytes.LastIndex(body, field)
+		if i < 0 {
+			return ""
+		}
+		body = append(body[:i:i], body[i+len(field):]...)
+	}
+	hash := sha256.New()
+	hash.Write([]byte(strconv.Itoa(resp.Status) + "\n" + resp.ContentType + "\n"))
+	hash.Write(body)
+	return `W/"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// encodedRequestIDField returns requestId field of an envelope encoded in mediaType,
+// it is a single field object encoded the same way without the object itself
+func encodedRequestIDField(mediaType string, requestID string) ([]byte, error) {
+	body, _, err := encodeResponseBody(mediaType, map[string]string{"requestId": requestID})
+	if err != nil {
+		return nil, err
+	}
+	switch mediaType {
+	case MediaTypeXML:
+		body = bytes.TrimPrefix(body, []byte(xml.Header+"<response>"))
+		return bytes.TrimSuffix(body, []byte("</response>")), nil
+	case MediaTypeMsgPack:
+		// fixmap header of one entry
+		return body[1:], nil
+	}
+	return bytes.TrimSpace(body[1 : len(body)-1]), nil
+}
+
+// etagMatches compares If-None-Match header with etag using weak comparison
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	opaque := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == opaque {
+			return true
+		}
+	}
+	return false
+}
+
+// headerListContains returns true when comma separated values of header key contain value
+func headerListContains(header http.Header, key string, value string) bool {
+	for _, values := range header[http.CanonicalHeaderKey(key)] {
+		for _, v := range strings.Split(values, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// negotiateEncoding returns "gzip" or "deflate" of the highest quality in Accept-Encoding, gzip wins a tie.
+// It returns empty string when neither is acceptable.
+func negotiateEncoding(acceptEncoding string) string {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		qualities[coding] = quality
+	}
+
+	best, bestQuality := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		quality, ok := qualities[coding]
+		if !ok {
+			quality, ok = qualities["*"]
+		}
+		if ok && quality > bestQuality {
+			best, bestQuality = coding, quality
+		}
+	}
+	return best
+}
+
+func compressBody(encoding string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	default:
+		// deflate content coding is zlib format, see RFC 7230 section 4.2.2
+		w = zlib.NewWriter(&buf)
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompressBody(encoding string, body []byte) ([]byte, error) {
+	var r io.ReadCloser
+	var err error
+	switch strings.ToLower(encoding) {
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
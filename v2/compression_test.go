@@ -0,0 +1,139 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestETagNotModifiedWithGeneratedRequestID(t *testing.T) {
+	handler := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		renderer := NewHTTPResultRenderer(w, req)
+		renderer.RenderJSONSuccess(map[string]interface{}{"name": "chanyut"})
+	}))
+
+	first := httptest.NewRecorder()
+	handler.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("first response: status %v, etag %q", first.Code, etag)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set("If-None-Match", etag)
+	second := httptest.NewRecorder()
+	handler.ServeHTTP(second, req)
+	if second.Code != http.StatusNotModified {
+		t.Fatalf("second response: status %v, want %v", second.Code, http.StatusNotModified)
+	}
+	if second.Body.Len() != 0 {
+		t.Fatalf("second response: body %q, want empty", second.Body.String())
+	}
+	if second.Header().Get(RequestIDHeader) == first.Header().Get(RequestIDHeader) {
+		t.Fatalf("request ids are expected to differ")
+	}
+}
+
+func TestETagPerRepresentation(t *testing.T) {
+	render := func(accept string, envelope string, requestID string) http.Header {
+		req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+		req.Header.Set("Accept", accept)
+		req.Header.Set(APIEnvelopeHeader, envelope)
+		req.Header.Set(RequestIDHeader, requestID)
+		w := httptest.NewRecorder()
+		renderer := NewHTTPResultRenderer(w, req)
+		renderer.RenderJSONSuccess(map[string]interface{}{"name": "chanyut", "requestId": "req-1"})
+		return w.Header()
+	}
+
+	base := render(MediaTypeJSON, string(EnvelopeV2), "req-1")
+	if vary := base.Get("Vary"); vary != "Accept, Accept-Encoding, "+APIEnvelopeHeader {
+		t.Fatalf("vary %q, want Accept, Accept-Encoding and %v", vary, APIEnvelopeHeader)
+	}
+	cases := []struct {
+		name      string
+		accept    string
+		envelope  string
+		requestID string
+		same      bool
+	}{
+		{"other request id", MediaTypeJSON, string(EnvelopeV2), "req-2", true},
+		{"v1 envelope", MediaTypeJSON, string(EnvelopeV1), "req-1", false},
+		{"both envelopes", MediaTypeJSON, string(EnvelopeBoth), "req-1", false},
+		{"xml", MediaTypeXML, string(EnvelopeV2), "req-1", false},
+		{"msgpack", MediaTypeMsgPack, string(EnvelopeV2), "req-1", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			etag := render(c.accept, c.envelope, c.requestID).Get("ETag")
+			if etag == "" || (etag == base.Get("ETag")) != c.same {
+				t.Fatalf("etag %q, base %q, want same %v", etag, base.Get("ETag"), c.same)
+			}
+		})
+	}
+}
+
+func TestETagIgnoresRequestIDInEveryFormat(t *testing.T) {
+	for _, accept := range []string{MediaTypeJSON, MediaTypeXML, MediaTypeMsgPack} {
+		t.Run(accept, func(t *testing.T) {
+			etags := map[string]bool{}
+			for _, requestID := range []string{"a", "req-1234567890"} {
+				req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+				req.Header.Set("Accept", accept)
+				req.Header.Set(RequestIDHeader, requestID)
+				w := httptest.NewRecorder()
+				renderer := NewHTTPResultRenderer(w, req)
+				renderer.RenderJSONSuccess([]string{"a", "b"})
+				etags[w.Header().Get("ETag")] = true
+			}
+			if len(etags) != 1 || etags[""] {
+				t.Fatalf("etags %v, want a single tag", etags)
+			}
+		})
+	}
+}
+
+func TestETagNotSetOnPost(t *testing.T) {
+	w := httptest.NewRecorder()
+	renderer := NewHTTPResultRenderer(w, httptest.NewRequest(http.MethodPost, "/users", nil))
+	renderer.RenderJSONSuccess("created")
+	if etag := w.Header().Get("ETag"); etag != "" {
+		t.Fatalf("etag %q, want empty", etag)
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	cases := []struct {
+		ifNoneMatch string
+		etag        string
+		want        bool
+	}{
+		{"", `W/"a"`, false},
+		{`W/"a"`, `W/"a"`, true},
+		{`"a"`, `W/"a"`, true},
+		{`"b", W/"a"`, `W/"a"`, true},
+		{`"b"`, `W/"a"`, false},
+		{"*", `W/"a"`, true},
+	}
+	for _, c := range cases {
+		if got := etagMatches(c.ifNoneMatch, c.etag); got != c.want {
+			t.Errorf("etagMatches(%q, %q) = %v, want %v", c.ifNoneMatch, c.etag, got, c.want)
+		}
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := map[string]string{
+		"":                          "",
+		"gzip, deflate":             "gzip",
+		"deflate;q=0.5, gzip;q=0.4": "deflate",
+		"*":                         "gzip",
+		"gzip;q=0, identity":        "",
+		"br":                        "",
+	}
+	for acceptEncoding, want := range cases {
+		if got := negotiateEncoding(acceptEncoding); got != want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", acceptEncoding, got, want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/revel/revel"
+)
+
+// configString reads revel config, it returns dfault when revel is not initialized e.g. in plain net/http services
+func configString(key string, dfault string) string {
+	if revel.Config == nil {
+		return dfault
+	}
+	return revel.Config.StringDefault(key, dfault)
+}
+
+// configBool reads revel config, it returns dfault when revel is not initialized
+func configBool(key string, dfault bool) bool {
+	if revel.Config == nil {
+		return dfault
+	}
+	return revel.Config.BoolDefault(key, dfault)
+}
+
+// configInt reads revel config, it returns dfault when revel is not initialized
+func configInt(key string, dfault int) int {
+	if revel.Config == nil {
+		return dfault
+	}
+	return revel.Config.IntDefault(key, dfault)
+}
+
+// configStrings reads comma separated list from revel config
+func configStrings(key string, dfault []string) []string {
+	value := configString(key, "")
+	if value == "" {
+		return dfault
+	}
+	values := []string{}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// configDuration reads duration e.g. "1m30s" from revel config, invalid value is logged and dfault is returned
+func configDuration(key string, dfault time.Duration) time.Duration {
+	value := configString(key, "")
+	if value == "" {
+		return dfault
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Println("[Config] invalid duration of", key, "due to error:", err)
+		return dfault
+	}
+	return duration
+}
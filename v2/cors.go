@@ -0,0 +1,190 @@
+package core
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/revel/revel"
+)
+
+// CORS answers preflight requests and adds CORS headers to responses of allowed origins.
+// An allowed origin is either "*", an exact origin, an origin with wildcard e.g. "https://*.example.com"
+// or a regular expression prefixed with "regex:" e.g. "regex:^https://[a-z]+\.example\.com$".
+// AllowedOrigins must not be changed once the CORS has handled a request. Credentials are never allowed
+// together with "*" since any website could then make credentialed requests, list the origins instead.
+type CORS struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+
+	compile  sync.Once
+	allowAll bool
+	origins  map[string]bool
+	patterns []*regexp.Regexp
+}
+
+// NewCORS creates CORS of allowed origins with default methods, headers and exposed headers of this package
+func NewCORS(allowedOrigins ...string) *CORS {
+	return &CORS{
+		AllowedOrigins: allowedOrigins,
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
+		AllowedHeaders: []string{"Accept", "Accept-Language", "Authorization", "Content-Type",
+			RequestIDHeader, IdempotencyKeyHeader, APIEnvelopeHeader, DefaultAPIKeyHeader},
+		ExposedHeaders: []string{RequestIDHeader, "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining",
+			"X-RateLimit-Reset", IdempotentReplayedHeader},
+		MaxAge: 10 * time.Minute,
+	}
+}
+
+// NewCORSByRevelConfig creates CORS from revel config, lists are comma separated:
+// "api.cors.allowed_origins", "api.cors.allowed_methods", "api.cors.allowed_headers",
+// "api.cors.exposed_headers", "api.cors.allow_credentials" and "api.cors.max_age" e.g. "10m".
+// Keys which are not set keep defaults of NewCORS.
+func NewCORSByRevelConfig() *CORS {
+	cors := NewCORS(configStrings("api.cors.allowed_origins", nil)...)
+	cors.AllowedMethods = configStrings("api.cors.allowed_methods", cors.AllowedMethods)
+	cors.AllowedHeaders = configStrings("api.cors.allowed_headers", cors.AllowedHeaders)
+	cors.ExposedHeaders = configStrings("api.cors.exposed_headers", cors.ExposedHeaders)
+	cors.AllowCredentials = configBool("api.cors.allow_credentials", false)
+	cors.MaxAge = configDuration("api.cors.max_age", cors.MaxAge)
+	if cors.AllowCredentials && containsString(cors.AllowedOrigins, "*") {
+		log.Println("[CORS] api.cors.allow_credentials is ignored since api.cors.allowed_origins contains *")
+		cors.AllowCredentials = false
+	}
+	return cors
+}
+
+// Filter is revel.Filter of the CORS, preflight requests are answered with 204 without reaching the action
+func (cors *CORS) Filter(c *revel.Controller, fc []revel.Filter) {
+	req := newRevelRenderRequest(c)
+	method, err := ParseRevelRequestMethodType(c.Request)
+	header, preflight := cors.headers(req, err == nil && method == RevelRequestMethodTypeOPTIONS)
+	for key := range header {
+		for _, value := range header[key] {
+			c.Response.Out.Header().Add(key, value)
+		}
+	}
+	if preflight {
+		c.Response.Status = http.StatusNoContent
+		c.Result = renderedResult{response: RenderedResponse{Status: http.StatusNoContent}, requestID: RequestIDOfController(c)}
+		return
+	}
+	fc[0](c, fc[1:])
+}
+
+// Middleware is Filter for net/http
+func (cors *CORS) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header, preflight := cors.headers(httpRenderRequest{req: r}, r.Method == http.MethodOptions)
+		for key := range header {
+			for _, value := range header[key] {
+				w.Header().Add(key, value)
+			}
+		}
+		if preflight {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// headers returns CORS headers of req, preflight is true when req is a preflight request to be answered
+// by the CORS itself. Requests of disallowed origins get only Vary header so browsers reject them.
+func (cors *CORS) headers(req RenderRequest, isOptions bool) (header http.Header, preflight bool) {
+	header = http.Header{}
+	header.Add("Vary", "Origin")
+	origin := req.Header("Origin")
+	requestMethod := req.Header("Access-Control-Request-Method")
+	preflight = isOptions && origin != "" && requestMethod != ""
+	if preflight {
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+	}
+	if origin == "" || !cors.isAllowedOrigin(origin) {
+		return header, preflight
+	}
+
+	if cors.allowAll {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+		if cors.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+
+	if !preflight {
+		if len(cors.ExposedHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(cors.ExposedHeaders, ", "))
+		}
+		return header, false
+	}
+
+	if !containsFold(cors.AllowedMethods, requestMethod) {
+		header.Del("Access-Control-Allow-Origin")
+		header.Del("Access-Control-Allow-Credentials")
+		return header, true
+	}
+	header.Set("Access-Control-Allow-Methods", strings.Join(cors.AllowedMethods, ", "))
+	if len(cors.AllowedHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(cors.AllowedHeaders, ", "))
+	}
+	if cors.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(cors.MaxAge/time.Second)))
+	}
+	return header, true
+}
+
+func (cors *CORS) isAllowedOrigin(origin string) bool {
+	cors.compile.Do(cors.compileOrigins)
+	if cors.allowAll || cors.origins[strings.ToLower(origin)] {
+		return true
+	}
+	for _, pattern := range cors.patterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileOrigins prepares AllowedOrigins for matching, invalid regular expressions are logged and skipped
+func (cors *CORS) compileOrigins() {
+	cors.origins = map[string]bool{}
+	for _, origin := range cors.AllowedOrigins {
+		switch {
+		case origin == "*":
+			cors.allowAll = true
+		case strings.HasPrefix(origin, "regex:"):
+			pattern, err := regexp.Compile(strings.TrimPrefix(origin, "regex:"))
+			if err != nil {
+				log.Println("[CORS] invalid origin pattern", origin, "due to error:", err)
+				continue
+			}
+			cors.patterns = append(cors.patterns, pattern)
+		case strings.Contains(origin, "*"):
+			pattern := "^" + strings.Replace(regexp.QuoteMeta(strings.ToLower(origin)), `\*`, `[a-z0-9.-]+`, -1) + "$"
+			cors.patterns = append(cors.patterns, regexp.MustCompile("(?i)"+pattern))
+		default:
+			cors.origins[strings.ToLower(origin)] = true
+		}
+	}
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serveCORS(cors *CORS, method string, origin string, requestMethod string) *httptest.ResponseRecorder {
+	handler := cors.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	req := httptest.NewRequest(method, "/users", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if requestMethod != "" {
+		req.Header.Set("Access-Control-Request-Method", requestMethod)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestCORSOriginMatching(t *testing.T) {
+	cors := NewCORS("https://app.example.com", "https://*.example.org", `regex:^http://localhost:\d+$`)
+	cases := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.com", true},
+		{"https://APP.example.com", true},
+		{"https://other.example.com", false},
+		{"https://a.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"https://a.example.org.evil.com", false},
+		{"http://localhost:3000", true},
+		{"http://localhost", false},
+		{"https://evil.com", false},
+	}
+	for _, c := range cases {
+		w := serveCORS(cors, http.MethodGet, c.origin, "")
+		got := w.Header().Get("Access-Control-Allow-Origin")
+		if c.allowed && got != c.origin {
+			t.Errorf("origin %q: Access-Control-Allow-Origin = %q, want the origin", c.origin, got)
+		}
+		if !c.allowed && got != "" {
+			t.Errorf("origin %q: Access-Control-Allow-Origin = %q, want empty", c.origin, got)
+		}
+		if w.Header().Get("Vary") != "Origin" {
+			t.Errorf("origin %q: Vary = %q, want Origin", c.origin, w.Header().Get("Vary"))
+		}
+		if w.Code != http.StatusOK {
+			t.Errorf("origin %q: status %v, want 200", c.origin, w.Code)
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	cors := NewCORS("https://app.example.com")
+	cors.AllowedMethods = []string{"GET", "POST"}
+
+	w := serveCORS(cors, http.MethodOptions, "https://app.example.com", "POST")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status %v, want 204", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST" {
+		t.Errorf("Access-Control-Allow-Methods = %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Max-Age"); got != "600" {
+		t.Errorf("Access-Control-Max-Age = %q, want 600", got)
+	}
+
+	w = serveCORS(cors, http.MethodOptions, "https://app.example.com", "DELETE")
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("rejected preflight: status %v, want 204", w.Code)
+	}
+	for _, key := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Methods", "Access-Control-Allow-Credentials"} {
+		if got := w.Header().Get(key); got != "" {
+			t.Errorf("rejected preflight: %v = %q, want empty", key, got)
+		}
+	}
+
+	w = serveCORS(cors, http.MethodOptions, "https://evil.com", "POST")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("preflight of disallowed origin: Access-Control-Allow-Origin = %q, want empty", got)
+	}
+
+	// OPTIONS without Access-Control-Request-Method is not a preflight and reaches the handler
+	w = serveCORS(cors, http.MethodOptions, "https://app.example.com", "")
+	if w.Code != http.StatusOK {
+		t.Errorf("plain OPTIONS: status %v, want 200", w.Code)
+	}
+}
+
+func TestCORSCredentials(t *testing.T) {
+	cors := NewCORS("https://app.example.com")
+	cors.AllowCredentials = true
+	w := serveCORS(cors, http.MethodGet, "https://app.example.com", "")
+	if w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("listed origin: credentials are expected to be allowed")
+	}
+
+	cors = NewCORS("*")
+	cors.AllowCredentials = true
+	w = serveCORS(cors, http.MethodGet, "https://evil.com", "")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("allow all: Access-Control-Allow-Origin = %q, want *", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("allow all: Access-Control-Allow-Credentials = %q, want empty", got)
+	}
+}
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"unicode"
+
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+// toGenericValue converts v into maps, slices and scalars through JSON so every format
+// serializes the same fields with the same names as json tags
+func toGenericValue(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var generic interface{}
+	err = decoder.Decode(&generic)
+	return generic, err
+}
+
+// encodeXML encodes v as XML document with root element, maps become child elements,
+// slices become repeated <item> elements
+func encodeXML(root string, v interface{}) ([]byte, error) {
+	generic, err := toGenericValue(v)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString(xml.Header)
+	if err := writeXMLElement(buf, root, generic); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeXMLElement(buf *bytes.Buffer, name string, v interface{}) error {
+	if isXMLName(name) {
+		buf.WriteString("<" + name + ">")
+	} else {
+		// keys which are not valid xml names e.g. "2020-08-01" are kept in an attribute
+		buf.WriteString(`<entry key="`)
+		if err := xml.EscapeText(buf, []byte(name)); err != nil {
+			return err
+		}
+		buf.WriteString(`">`)
+		name = "entry"
+	}
+
+	switch value := v.(type) {
+	case nil:
+	case map[string]interface{}:
+		for _, key := range sortedKeys(value) {
+			if err := writeXMLElement(buf, key, value[key]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range value {
+			if err := writeXMLElement(buf, "item", item); err != nil {
+				return err
+			}
+		}
+	default:
+		if err := xml.EscapeText(buf, []byte(fmt.Sprint(value))); err != nil {
+			return err
+		}
+	}
+
+	buf.WriteString("</" + name + ">")
+	return nil
+}
+
+func isXMLName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if unicode.IsLetter(r) || r == '_' {
+			continue
+		}
+		if i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// encodeMsgPack encodes v as MessagePack, numbers use the most compact form and map keys are sorted
+func encodeMsgPack(v interface{}) ([]byte, error) {
+	generic, err := toGenericValue(v)
+	if err != nil {
+		return nil, err
+	}
+	generic, err = msgPackNumbers(generic)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	encoder := msgpack.NewEncoder(buf).SortMapKeys(true).UseCompactEncoding(true)
+	if err := encoder.Encode(generic); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// msgPackNumbers replaces json.Number of generic value with int64, uint64 or float64,
+// otherwise they would be encoded as strings
+func msgPackNumbers(v interface{}) (interface{}, error) {
+	switch value := v.(type) {
+	case json.Number:
+		if n, err := strconv.ParseInt(string(value), 10, 64); err == nil {
+			return n, nil
+		}
+		if n, err := strconv.ParseUint(string(value), 10, 64); err == nil {
+			return n, nil
+		}
+		return value.Float64()
+	case []interface{}:
+		for i := range value {
+			item, err := msgPackNumbers(value[i])
+			if err != nil {
+				return nil, err
+			}
+			value[i] = item
+		}
+	case map[string]interface{}:
+		for key := range value {
+			item, err := msgPackNumbers(value[key])
+			if err != nil {
+				return nil, err
+			}
+			value[key] = item
+		}
+	}
+	return v, nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
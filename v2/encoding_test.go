@@ -0,0 +1,169 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v4"
+)
+
+func decodeMsgPack(t *testing.T, data []byte) interface{} {
+	var v interface{}
+	if err := msgpack.Unmarshal(data, &v); err != nil {
+		t.Fatalf("failed to decode msgpack %x: %v", data, err)
+	}
+	return normalizeMsgPackValue(v)
+}
+
+// normalizeMsgPackValue converts integers decoded in their encoded width into int64, or uint64 when
+// they do not fit int64, so expected values do not depend on the chosen format
+func normalizeMsgPackValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case []interface{}:
+		for i := range value {
+			value[i] = normalizeMsgPackValue(value[i])
+		}
+		return value
+	case map[string]interface{}:
+		for key := range value {
+			value[key] = normalizeMsgPackValue(value[key])
+		}
+		return value
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() > math.MaxInt64 {
+			return rv.Uint()
+		}
+		return int64(rv.Uint())
+	}
+	return v
+}
+
+func TestEncodeMsgPackNumbers(t *testing.T) {
+	cases := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{"int", 7, int64(7)},
+		{"negative int", -100, int64(-100)},
+		{"int64", int64(math.MinInt64), int64(math.MinInt64)},
+		{"uint64", uint64(math.MaxUint64), uint64(math.MaxUint64)},
+		{"float", 1.5, 1.5},
+		{"nested", map[string]interface{}{"a": []interface{}{1, "go"}, "b": nil}, map[string]interface{}{
+			"a": []interface{}{int64(1), "go"},
+			"b": nil,
+		}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := encodeMsgPack(c.value)
+			if err != nil {
+				t.Fatalf("encodeMsgPack() error: %v", err)
+			}
+			if got := decodeMsgPack(t, data); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("decoded %#v, want %#v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestEncodeMsgPackEnvelope(t *testing.T) {
+	data, err := encodeMsgPack(JSONResponse{Success: true, Data: map[string]interface{}{"name": "chanyut"}, RequestID: "abc"})
+	if err != nil {
+		t.Fatalf("encodeMsgPack() error: %v", err)
+	}
+	want := map[string]interface{}{
+		"success":   true,
+		"data":      map[string]interface{}{"name": "chanyut"},
+		"error":     nil,
+		"requestId": "abc",
+	}
+	if got := decodeMsgPack(t, data); !reflect.DeepEqual(got, want) {
+		t.Fatalf("decoded %#v, want %#v", got, want)
+	}
+}
+
+func TestEncodeXML(t *testing.T) {
+	cases := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"nil", nil, `<response></response>`},
+		{"scalar", 1.5, `<response>1.5</response>`},
+		{"escaped text", map[string]interface{}{"name": `<a&b>"'`}, `<response><name>&lt;a&amp;b&gt;&#34;&#39;</name></response>`},
+		{"invalid names in attribute", map[string]interface{}{"2020-08-01": "x", `a"b<`: 1, "": true},
+			`<response><entry key="">true</entry><entry key="2020-08-01">x</entry><entry key="a&#34;b&lt;">1</entry></response>`},
+		{"sorted keys", map[string]interface{}{"b": 2, "a": 1}, `<response><a>1</a><b>2</b></response>`},
+		{"slice", []string{"go", "<db>"}, `<response><item>go</item><item>&lt;db&gt;</item></response>`},
+		{"nested", map[string]interface{}{
+			"user": map[string]interface{}{"tags": []interface{}{[]int{1}, map[string]string{"k": "v"}, nil}},
+		}, `<response><user><tags><item><item>1</item></item><item><k>v</k></item><item></item></tags></user></response>`},
+		{"struct uses json tags", struct {
+			Name  string `json:"name"`
+			Empty string `json:"empty,omitempty"`
+		}{Name: "chanyut"}, `<response><name>chanyut</name></response>`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			data, err := encodeXML("response", c.value)
+			if err != nil {
+				t.Fatalf("encodeXML() error: %v", err)
+			}
+			if want := xml.Header + c.want; string(data) != want {
+				t.Fatalf("encodeXML() = %s, want %s", data, want)
+			}
+
+			decoder := xml.NewDecoder(bytes.NewReader(data))
+			for {
+				_, err := decoder.Token()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("encoded document is not well-formed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderNotAcceptable(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	renderer := NewHTTPResultRenderer(w, req)
+	renderer.RenderJSONSuccess("chanyut")
+
+	if w.Code != http.StatusNotAcceptable {
+		t.Fatalf("status %v, want %v", w.Code, http.StatusNotAcceptable)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != MediaTypeJSON+"; charset=utf-8" {
+		t.Errorf("content type %q, want JSON", contentType)
+	}
+	var resp struct {
+		Success bool
+		Data    interface{}
+		Error   struct{ Code string }
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode body %s: %v", w.Body.String(), err)
+	}
+	if resp.Success || resp.Data != nil || resp.Error.Code != ErrorCodeNotAcceptable.Code {
+		t.Errorf("body %s, want %v error without data", w.Body.String(), ErrorCodeNotAcceptable.Code)
+	}
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// APIEnvelopeHeader lets clients choose response envelope, values are "v1", "v2" or "both"
+const APIEnvelopeHeader = "X-API-Envelope"
+
+// EnvelopeVersion is shape of rendered JSONResponse
+type EnvelopeVersion string
+
+const (
+	// EnvelopeV1 is {success, data, errors: []string} of the root package
+	EnvelopeV1 EnvelopeVersion = "v1"
+	// EnvelopeV2 is {success, data, error: APIError}, this is the default
+	EnvelopeV2 EnvelopeVersion = "v2"
+	// EnvelopeBoth has both errors and error fields for gateways serving clients of both versions
+	EnvelopeBoth EnvelopeVersion = "both"
+)
+
+// JSONResponseV1 is envelope of the root package
+type JSONResponseV1 struct {
+	Success   bool        `json:"success"`
+	Data      interface{} `json:"data"`
+	Errors    []string    `json:"errors"`
+	RequestID string      `json:"requestId,omitempty"`
+}
+
+// compatJSONResponse is EnvelopeBoth
+type compatJSONResponse struct {
+	Success   bool        `json:"success"`
+	Data      interface{} `json:"data"`
+	Error     *APIError   `json:"error"`
+	Errors    []string    `json:"errors"`
+	RequestID string      `json:"requestId,omitempty"`
+}
+
+// envelopeVersion returns envelope version from X-API-Envelope header, then route prefixes
+// in "api.envelope.v1_prefixes" (comma separated e.g. "/v1/", none by default), then "api.envelope.default"
+func envelopeVersion(req RenderRequest) EnvelopeVersion {
+	switch version := EnvelopeVersion(strings.ToLower(req.Header(APIEnvelopeHeader))); version {
+	case EnvelopeV1, EnvelopeV2, EnvelopeBoth:
+		return version
+	}
+	path := req.Path()
+	for _, prefix := range configStrings("api.envelope.v1_prefixes", nil) {
+		if strings.HasPrefix(path, prefix) {
+			return EnvelopeV1
+		}
+	}
+	return EnvelopeVersion(configString("api.envelope.default", string(EnvelopeV2)))
+}
+
+// toEnvelope converts resp into envelope version requested by req
+func toEnvelope(req RenderRequest, resp JSONResponse) interface{} {
+	switch envelopeVersion(req) {
+	case EnvelopeV1:
+		return JSONResponseV1{
+			Success:   resp.Success,
+			Data:      resp.Data,
+			Errors:    v1ErrorStrings(resp.Error),
+			RequestID: resp.RequestID,
+		}
+	case EnvelopeBoth:
+		return compatJSONResponse{
+			Success:   resp.Success,
+			Data:      resp.Data,
+			Error:     resp.Error,
+			Errors:    v1ErrorStrings(resp.Error),
+			RequestID: resp.RequestID,
+		}
+	}
+	return resp
+}
+
+// v1ErrorStrings returns message of err, or "key - message" of every field like GenericErrorFromValidationErrors of v1
+func v1ErrorStrings(err *APIError) []string {
+	if err == nil {
+		return nil
+	}
+	if len(err.Fields) == 0 {
+		return []string{err.Message}
+	}
+	errors := make([]string, 0, len(err.Fields))
+	for _, field := range err.Fields {
+		errors = append(errors, fmt.Sprintf("%s - %s", field.Key, field.Message))
+	}
+	return errors
+}
+
+// DecodeJSONResponse decodes response body of either v1 or v2 envelope. Data is decoded into data when it is not nil,
+// and v1 errors become an APIError with the errors joined by new line as Message
+func DecodeJSONResponse(body []byte, data interface{}) (JSONResponse, error) {
+	envelope := struct {
+		Success   bool            `json:"success"`
+		Data      json.RawMessage `json:"data"`
+		Error     *APIError       `json:"error"`
+		Errors    []string        `json:"errors"`
+		RequestID string          `json:"requestId"`
+	}{}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return JSONResponse{}, err
+	}
+
+	resp := JSONResponse{
+		Success:   envelope.Success,
+		Error:     envelope.Error,
+		RequestID: envelope.RequestID,
+	}
+	if resp.Error == nil && len(envelope.Errors) > 0 {
+		resp.Error = &APIError{Message: strings.Join(envelope.Errors, "\n")}
+	}
+	if len(envelope.Data) > 0 && string(envelope.Data) != "null" {
+		if data == nil {
+			var generic interface{}
+			if err := json.Unmarshal(envelope.Data, &generic); err != nil {
+				return resp, err
+			}
+			resp.Data = generic
+		} else {
+			if err := json.Unmarshal(envelope.Data, data); err != nil {
+				return resp, err
+			}
+			resp.Data = data
+		}
+	}
+	return resp, nil
+}
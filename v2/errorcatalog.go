@@ -0,0 +1,143 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/revel/revel"
+)
+
+// ErrorCode declares an API error once for the whole application, declare it with RegisterErrorCode
+// at package level so duplicated ids or codes are detected at init.
+// IDs below 1000 are reserved for errors of this package.
+type ErrorCode struct {
+	ID         int    `json:"id"`
+	Code       string `json:"code"`
+	HTTPStatus int    `json:"httpStatus"`
+	// Message is default message, it is a fmt template when the error is created with args
+	Message string `json:"message"`
+	// MessageKey is i18n key of the message, the translation is a fmt template as well
+	MessageKey string `json:"messageKey,omitempty"`
+	DocsURL    string `json:"docsUrl,omitempty"`
+}
+
+var (
+	errorCatalogLock   sync.RWMutex
+	errorCatalog       = map[int]ErrorCode{}
+	errorCatalogByCode = map[string]ErrorCode{}
+)
+
+var (
+	ErrorCodeUnspecified = RegisterErrorCode(ErrorCode{
+		ID:         1,
+		Code:       "unspecified_error",
+		HTTPStatus: 500,
+		Message:    "Internal server error",
+		MessageKey: "core.error.unspecified_error",
+	})
+	ErrorCodeValidationFailed = RegisterErrorCode(ErrorCode{
+		ID:         2,
+		Code:       "validation_failed",
+		HTTPStatus: 400,
+		Message:    "Validation failed",
+		MessageKey: "core.error.validation_failed",
+	})
+	ErrorCodeNotFound = RegisterErrorCode(ErrorCode{
+		ID:         3,
+		Code:       "not_found",
+		HTTPStatus: 404,
+		Message:    "Resource not found",
+		MessageKey: "core.error.not_found",
+	})
+	ErrorCodeDuplicated = RegisterErrorCode(ErrorCode{
+		ID:         4,
+		Code:       "duplicated",
+		HTTPStatus: 409,
+		Message:    "Resource already exists",
+		MessageKey: "core.error.duplicated",
+	})
+	ErrorCodeTimeout = RegisterErrorCode(ErrorCode{
+		ID:         5,
+		Code:       "timeout",
+		HTTPStatus: 504,
+		Message:    "Request timed out",
+		MessageKey: "core.error.timeout",
+	})
+)
+
+// Error makes code usable as errors.Is target
+func (code ErrorCode) Error() string {
+	return fmt.Sprintf("[Err-%v] %v", code.ID, code.Code)
+}
+
+// RegisterErrorCode adds code into error catalog and returns it, it panics when id or code is already registered
+func RegisterErrorCode(code ErrorCode) ErrorCode {
+	if code.Code == "" {
+		panic(fmt.Errorf("[ErrorCatalog] error %v has no code", code.ID))
+	}
+	if code.HTTPStatus == 0 {
+		panic(fmt.Errorf("[ErrorCatalog] error %v (%v) has no http status", code.ID, code.Code))
+	}
+
+	errorCatalogLock.Lock()
+	defer errorCatalogLock.Unlock()
+	if existing, ok := errorCatalog[code.ID]; ok {
+		panic(fmt.Errorf("[ErrorCatalog] duplicated error id %v: %v and %v", code.ID, existing.Code, code.Code))
+	}
+	if existing, ok := errorCatalogByCode[code.Code]; ok {
+		panic(fmt.Errorf("[ErrorCatalog] duplicated error code %v: id %v and %v", code.Code, existing.ID, code.ID))
+	}
+	errorCatalog[code.ID] = code
+	errorCatalogByCode[code.Code] = code
+	return code
+}
+
+// LookupErrorCode returns registered error code of id
+func LookupErrorCode(id int) (ErrorCode, bool) {
+	errorCatalogLock.RLock()
+	defer errorCatalogLock.RUnlock()
+	code, ok := errorCatalog[id]
+	return code, ok
+}
+
+// ErrorCatalog returns all registered error codes sorted by id
+func ErrorCatalog() []ErrorCode {
+	errorCatalogLock.RLock()
+	defer errorCatalogLock.RUnlock()
+	codes := make([]ErrorCode, 0, len(errorCatalog))
+	for _, code := range errorCatalog {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i].ID < codes[j].ID })
+	return codes
+}
+
+// ExportErrorCatalogJSON returns error catalog as JSON for client teams
+func ExportErrorCatalogJSON() ([]byte, error) {
+	return json.MarshalIndent(ErrorCatalog(), "", "  ")
+}
+
+// NewAPIErrorFromCode creates APIError of registered code, args are applied to message template of the code
+func NewAPIErrorFromCode(code ErrorCode, err error, args ...interface{}) *APIError {
+	message := code.Message
+	if len(args) > 0 {
+		message = fmt.Sprintf(code.Message, args...)
+	}
+	apiError := NewAPIError(code.ID, code.HTTPStatus, message, err)
+	apiError.Code = code.Code
+	apiError.MessageKey = code.MessageKey
+	apiError.MessageArgs = args
+	return apiError
+}
+
+// New is shorthand of NewAPIErrorFromCode
+func (code ErrorCode) New(err error, args ...interface{}) *APIError {
+	return NewAPIErrorFromCode(code, err, args...)
+}
+
+// RenderErrorCatalog renders error catalog, it is meant to be exposed as an endpoint for client teams
+func (r *RevelResultRenderer) RenderErrorCatalog() revel.Result {
+	return r.RenderJSONSuccess(ErrorCatalog())
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/revel/revel"
+)
+
+// FieldErrorCodeInvalid is code of field errors coming from revel Validation, which does not tell the failed rule
+const FieldErrorCodeInvalid = "invalid"
+
+// sensitiveFieldPattern matches keys whose values must never be sent back, anywhere in the key
+// e.g. "passwordConfirmation", "confirm_password", "items[0].pin"
+var sensitiveFieldPattern = regexp.MustCompile(`(?i)(password|passwd|secret|token|pin|otp|api_?key|credential)`)
+
+// FieldError describes why a field of request is invalid so clients can highlight the field.
+// RejectedValue is omitted for sensitive fields, see RegisterAuditMaskedFields and sensitiveFieldPattern.
+type FieldError struct {
+	Key           string      `json:"key"`
+	Message       string      `json:"message"`
+	Code          string      `json:"code"`
+	RejectedValue interface{} `json:"rejectedValue,omitempty"`
+}
+
+// NewFieldError creates FieldError, rejectedValue is dropped when key or the value looks sensitive
+func NewFieldError(key string, message string, code string, rejectedValue interface{}) FieldError {
+	if sensitiveFieldPattern.MatchString(key) || isAuditMaskedField(key, rejectedValue) {
+		rejectedValue = nil
+	}
+	return FieldError{
+		Key:           key,
+		Message:       message,
+		Code:          code,
+		RejectedValue: rejectedValue,
+	}
+}
+
+// NewValidationAPIError creates 400 error of ErrorCodeValidationFailed carrying fields,
+// Message is all field messages joined by new line
+func NewValidationAPIError(fields []FieldError) *APIError {
+	message := ""
+	for _, field := range fields {
+		message += fmt.Sprintf("%s: %s\n", field.Key, field.Message)
+	}
+	apiError := NewAPIErrorFromCode(ErrorCodeValidationFailed, nil)
+	apiError.Message = message
+	apiError.MessageKey = ""
+	apiError.Fields = fields
+	return apiError
+}
+
+// FieldErrorsFromRevelValidation converts revel validation errors, rejected values are read from params when it is not nil
+func FieldErrorsFromRevelValidation(validationErrors []*revel.ValidationError, params *revel.Params) []FieldError {
+	fields := make([]FieldError, 0, len(validationErrors))
+	for _, err := range validationErrors {
+		var rejectedValue interface{}
+		if params != nil && params.Values != nil {
+			if value := params.Get(err.Key); value != "" {
+				rejectedValue = value
+			}
+		}
+		fields = append(fields, NewFieldError(err.Key, err.Message, FieldErrorCodeInvalid, rejectedValue))
+	}
+	return fields
+}
+
+// RenderValidationErrors renders 400 error carrying fields
+func (r *RevelResultRenderer) RenderValidationErrors(fields []FieldError) revel.Result {
+	return r.RenderJSONError(NewValidationAPIError(fields))
+}
@@ -0,0 +1,31 @@
+package core
+
+import "testing"
+
+func TestNewFieldErrorOmitsSensitiveValues(t *testing.T) {
+	sensitive := []string{
+		"password",
+		"Password",
+		"passwordConfirmation",
+		"confirm_password",
+		"newPassword",
+		"user.pin",
+		"otp",
+		"items[0].secret",
+		"tokens[1]",
+		"apiKey",
+	}
+	for _, key := range sensitive {
+		if field := NewFieldError(key, "is invalid", "invalid", "plaintext"); field.RejectedValue != nil {
+			t.Errorf("NewFieldError(%q) keeps rejected value %v", key, field.RejectedValue)
+		}
+	}
+
+	if field := NewFieldError("name", "is invalid", "invalid", "chanyut"); field.RejectedValue != "chanyut" {
+		t.Errorf("NewFieldError(name) rejected value = %v, want chanyut", field.RejectedValue)
+	}
+	hash := "$2a$10$" + "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0"
+	if field := NewFieldError("name", "is invalid", "invalid", hash); field.RejectedValue != nil {
+		t.Errorf("NewFieldError keeps bcrypt hash as rejected value")
+	}
+}
@@ -0,0 +1,21 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/revel/revel"
+)
+
+// newTestController creates revel controller serving r, its response is written into w or discarded when w is nil
+func newTestController(r *http.Request, w http.ResponseWriter) *revel.Controller {
+	if w == nil {
+		w = httptest.NewRecorder()
+	}
+	ctx := revel.NewGoContext(nil)
+	ctx.Request.SetRequest(r)
+	ctx.Response.SetResponse(w)
+	c := revel.NewController(ctx)
+	c.Response.Status = http.StatusOK
+	return c
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/revel/revel"
+)
+
+// localizeAPIError returns copy of err with Message resolved from its MessageKey in locale of the request.
+// Locales are tried in order: locales of the request (revel locale, Accept-Language), i18n.default_language.
+// Message of err is kept when no translation is found.
+func localizeAPIError(req RenderRequest, err *APIError) *APIError {
+	if err.MessageKey == "" {
+		return err
+	}
+	locales := req.Locales()
+	if defaultLanguage := configString("i18n.default_language", ""); defaultLanguage != "" {
+		locales = append(locales, defaultLanguage)
+	}
+	message, ok := localizeMessage(locales, err.MessageKey, err.MessageArgs...)
+	if !ok {
+		return err
+	}
+	localized := *err
+	localized.Message = message
+	return &localized
+}
+
+// localizeMessage looks up key in each locale and formats the first translation found with args.
+// Args are formatted as is, unlike revel.Message which escapes them for HTML.
+func localizeMessage(locales []string, key string, args ...interface{}) (string, bool) {
+	if revel.Config == nil {
+		return "", false
+	}
+	unknown := fmt.Sprintf(configString("i18n.unknown_format", "??? %s ???"), key)
+	for _, locale := range locales {
+		locale = strings.TrimSpace(locale)
+		if locale == "" {
+			continue
+		}
+		message := revel.MessageFunc(locale, key)
+		if message == unknown {
+			continue
+		}
+		if len(args) > 0 {
+			message = fmt.Sprintf(message, args...)
+		}
+		return message, true
+	}
+	return "", false
+}
+
+// CheckErrorCatalogTranslations verifies that message key of every registered error code has translation
+// in all loaded message languages. Call it after revel is initialized e.g. in an OnAppStart hook.
+func CheckErrorCatalogTranslations() error {
+	languages := revel.MessageLanguages()
+	sort.Strings(languages)
+	missing := []string{}
+	for _, code := range ErrorCatalog() {
+		if code.MessageKey == "" {
+			continue
+		}
+		for _, lang := range languages {
+			if _, ok := localizeMessage([]string{lang}, code.MessageKey); !ok {
+				missing = append(missing, fmt.Sprintf("%s[%s]", code.MessageKey, lang))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("[ErrorCatalog] missing translations: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
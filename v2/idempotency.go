@@ -0,0 +1,444 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/revel/revel"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// IdempotencyKeyHeader is header clients send to make retries of a request safe
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader is set to "true" on responses replayed from a stored response
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// IdempotencyKeyCollectionName is the collection which idempotency keys and their responses are stored
+const IdempotencyKeyCollectionName = "idempotency_keys"
+
+// maxIdempotencyKeyLength limits idempotency keys accepted from clients
+const maxIdempotencyKeyLength = 255
+
+// IdempotencyKeyState is state of a stored idempotency key
+type IdempotencyKeyState string
+
+const (
+	IdempotencyKeyStateProcessing IdempotencyKeyState = "processing"
+	IdempotencyKeyStateCompleted  IdempotencyKeyState = "completed"
+)
+
+var (
+	ErrorCodeIdempotencyKeyReused = RegisterErrorCode(ErrorCode{
+		ID:         11,
+		Code:       "idempotency_key_reused",
+		HTTPStatus: 409,
+		Message:    "Idempotency key has been used with a different request",
+		MessageKey: "core.error.idempotency_key_reused",
+	})
+	ErrorCodeIdempotencyKeyInProgress = RegisterErrorCode(ErrorCode{
+		ID:         12,
+		Code:       "idempotency_key_in_progress",
+		HTTPStatus: 409,
+		Message:    "Request with the same idempotency key is in progress",
+		MessageKey: "core.error.idempotency_key_in_progress",
+	})
+	ErrorCodeInvalidIdempotencyKey = RegisterErrorCode(ErrorCode{
+		ID:         13,
+		Code:       "invalid_idempotency_key",
+		HTTPStatus: 400,
+		Message:    "Idempotency key must be printable ASCII of at most %v characters",
+		MessageKey: "core.error.invalid_idempotency_key",
+	})
+)
+
+// IdempotencyKey is a document stored in idempotency_keys collection, its id is the key prefixed with scope of the caller
+type IdempotencyKey struct {
+	ID          string              `bson:"_id"`
+	State       IdempotencyKeyState `bson:"state"`
+	Fingerprint string              `bson:"fingerprint"`
+	Status      int                 `bson:"status,omitempty"`
+	Header      http.Header         `bson:"header,omitempty"`
+	ContentType string              `bson:"contentType,omitempty"`
+	Body        []byte              `bson:"body,omitempty"`
+	LockedUntil time.Time           `bson:"lockedUntil"`
+	CreatedAt   time.Time           `bson:"createdAt"`
+	ExpireAt    time.Time           `bson:"expireAt"`
+}
+
+// Idempotency replays stored response of non-idempotent requests (POST, PATCH) carrying Idempotency-Key.
+// The first request locks the key while it is processed, a concurrent duplicate gets 409 and a retry
+// after completion gets the stored response. Using the key with another method, path or payload gets 409.
+// Responses with status 5xx are not stored so the request can be retried. Keys are scoped by caller,
+// so callers sending the same key never see responses of each other.
+type Idempotency struct {
+	// TTL is how long responses are kept for replay
+	TTL time.Duration
+	// LockTimeout is how long a key stays locked when its request never completes e.g. the process crashed
+	LockTimeout time.Duration
+	// Scope returns identity of the caller, default is IdempotencyScopeByCaller
+	Scope func(req RenderRequest) string
+
+	session *mgo.Session
+	dbName  string
+}
+
+// NewIdempotency creates Idempotency on a copy of session of mgoDb and ensures TTL index of its collection
+func NewIdempotency(mgoDb *MgoDb, ttl time.Duration) (*Idempotency, error) {
+	idempotency := &Idempotency{
+		TTL:         ttl,
+		LockTimeout: time.Minute,
+		Scope:       IdempotencyScopeByCaller,
+		session:     mgoDb.Session.Copy(),
+		dbName:      mgoDb.Db.Name,
+	}
+	err := idempotency.session.DB(idempotency.dbName).C(IdempotencyKeyCollectionName).EnsureIndex(mgo.Index{
+		Key:         []string{"expireAt"},
+		ExpireAfter: time.Second,
+		Background:  true,
+	})
+	if err != nil {
+		idempotency.session.Close()
+		return nil, fmt.Errorf("[Idempotency] failed to ensure index due to error: %v", err)
+	}
+	return idempotency, nil
+}
+
+// IdempotencyScopeByCaller identifies caller by Authorization and X-API-Key headers,
+// anonymous callers are identified by client IP
+func IdempotencyScopeByCaller(req RenderRequest) string {
+	authorization := req.Header("Authorization")
+	apiKey := req.Header(DefaultAPIKeyHeader)
+	if authorization == "" && apiKey == "" {
+		if ip := remoteIP(req.RemoteAddr()); ip != nil {
+			return "ip:" + ip.String()
+		}
+		return "ip:" + req.RemoteAddr()
+	}
+	return "auth:" + authorization + "\n" + apiKey
+}
+
+// Close closes session of the idempotency
+func (i *Idempotency) Close() {
+	i.session.Close()
+}
+
+// Filter is revel.Filter of the idempotency, only responses rendered by RevelResultRenderer are stored.
+// Fingerprint of the request is computed from JSON body and form values parsed by revel.ParamsFilter,
+// so it must be placed after revel.ParamsFilter, otherwise it panics.
+func (i *Idempotency) Filter(c *revel.Controller, fc []revel.Filter) {
+	if c.Params == nil || c.Params.Values == nil {
+		panic("[Idempotency] Filter must be placed after revel.ParamsFilter")
+	}
+	req := newRevelRenderRequest(c)
+	method, err := ParseRevelRequestMethodType(c.Request)
+	key := req.Header(IdempotencyKeyHeader)
+	if err != nil || method.IsIdempotent() || key == "" {
+		fc[0](c, fc[1:])
+		return
+	}
+
+	key, apiErr := i.scopedKey(req, key)
+	if apiErr != nil {
+		renderer := NewRevelResultRenderer(c)
+		c.Result = renderer.RenderJSONError(apiErr)
+		return
+	}
+	fingerprint := idempotencyFingerprint(string(method), req.Path(), c.Params.JSON, []byte(c.Params.Form.Encode()))
+	replay, apiErr := i.begin(key, fingerprint)
+	if apiErr != nil {
+		renderer := NewRevelResultRenderer(c)
+		c.Result = renderer.RenderJSONError(apiErr)
+		return
+	}
+	if replay != nil {
+		c.Response.Status = replay.Status
+		c.Result = renderedResult{response: *replay, requestID: req.RequestID()}
+		return
+	}
+
+	completed := false
+	defer func() {
+		if !completed {
+			i.release(req, key)
+		}
+	}()
+	fc[0](c, fc[1:])
+	completed = true
+
+	rendered, ok := c.Result.(renderedResult)
+	if !ok {
+		logf(req.RequestID(), "[Idempotency] response of %T cannot be stored, key %q is released", c.Result, key)
+		i.release(req, key)
+		return
+	}
+	i.complete(req, key, rendered.response)
+}
+
+// Middleware is Filter for net/http, the request body is read to compute fingerprint of the request,
+// bodies larger than "api.max_body_size" are rejected with 413
+func (i *Idempotency) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := httpRenderRequest{req: r}
+		method, err := ParseRequestMethodType(r.Method)
+		key := req.Header(IdempotencyKeyHeader)
+		if err != nil || method.IsIdempotent() || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, apiErr := i.scopedKey(req, key)
+		if apiErr != nil {
+			renderer := NewHTTPResultRenderer(w, r)
+			renderer.RenderJSONError(apiErr)
+			return
+		}
+
+		maxBodySize := configuredMaxBodySize()
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+		if err != nil {
+			renderer := NewHTTPResultRenderer(w, r)
+			renderer.RenderJSONError(NewAPIErrorFromCode(ErrorCodeMalformedBody, err, err.Error()))
+			return
+		}
+		if int64(len(body)) > maxBodySize {
+			// fingerprint of a cut body could match another request, such request is rejected like MaxBodySizeFilter does
+			renderer := NewHTTPResultRenderer(w, r)
+			renderer.RenderJSONError(NewAPIErrorFromCode(ErrorCodeBodyTooLarge, nil, maxBodySize))
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		replay, apiErr := i.begin(key, idempotencyFingerprint(string(method), r.URL.Path, body))
+		if apiErr != nil {
+			renderer := NewHTTPResultRenderer(w, r)
+			renderer.RenderJSONError(apiErr)
+			return
+		}
+		if replay != nil {
+			if err := finalizeResponse(req, *replay).WriteTo(httpRenderWriter{w: w}); err != nil {
+				logf(req.RequestID(), "[Idempotency] failed to write replayed response due to error: %v", err)
+			}
+			return
+		}
+
+		header := w.Header().Clone()
+		recorder := &idempotencyRecorder{ResponseWriter: w}
+		completed := false
+		defer func() {
+			if !completed {
+				i.release(req, key)
+			}
+		}()
+		next.ServeHTTP(recorder, r)
+		completed = true
+
+		resp, err := uncompressedResponse(RenderedResponse{
+			Status:      recorder.status(),
+			Header:      headerChanges(header, w.Header()),
+			ContentType: w.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+		})
+		if err != nil {
+			logf(req.RequestID(), "[Idempotency] response cannot be stored due to error: %v, key %q is released", err, key)
+			i.release(req, key)
+			return
+		}
+		i.complete(req, key, resp)
+	})
+}
+
+// uncompressedResponse reverts finalizeResponse so the stored response is finalized again for the retry,
+// which may accept another encoding
+func uncompressedResponse(resp RenderedResponse) (RenderedResponse, error) {
+	if encoding := resp.Header.Get("Content-Encoding"); encoding != "" {
+		body, err := decompressBody(encoding, resp.Body)
+		if err != nil {
+			return resp, err
+		}
+		resp.Body = body
+	}
+	for _, key := range []string{"Content-Encoding", "Content-Length", "ETag", "Vary"} {
+		resp.Header.Del(key)
+	}
+	return resp, nil
+}
+
+// scopedKey validates key of the request and prefixes it with hash of scope of the caller
+func (i *Idempotency) scopedKey(req RenderRequest, key string) (string, *APIError) {
+	if len(key) > maxIdempotencyKeyLength || !isPrintableASCII(key) {
+		return "", NewAPIErrorFromCode(ErrorCodeInvalidIdempotencyKey, nil, maxIdempotencyKeyLength)
+	}
+	scope := ""
+	if i.Scope != nil {
+		scope = i.Scope(req)
+	}
+	sum := sha256.Sum256([]byte(scope))
+	return hex.EncodeToString(sum[:]) + ":" + key, nil
+}
+
+// begin locks scoped key for processing, it returns stored response when the request is a retry of a completed one
+func (i *Idempotency) begin(key string, fingerprint string) (*RenderedResponse, *APIError) {
+	session := i.session.Copy()
+	defer session.Close()
+	col := session.DB(i.dbName).C(IdempotencyKeyCollectionName)
+
+	now := time.Now()
+	err := col.Insert(IdempotencyKey{
+		ID:          key,
+		State:       IdempotencyKeyStateProcessing,
+		Fingerprint: fingerprint,
+		LockedUntil: now.Add(i.LockTimeout),
+		CreatedAt:   now,
+		ExpireAt:    now.Add(i.TTL),
+	})
+	if err == nil {
+		return nil, nil
+	}
+	if !mgo.IsDup(err) {
+		return nil, NewAPIErrorFromCode(ErrorCodeUnspecified, err)
+	}
+
+	stored := IdempotencyKey{}
+	if err := col.FindId(key).One(&stored); err != nil {
+		if err == mgo.ErrNotFound {
+			// the key is released or expired in the meantime
+			return nil, ErrorCodeIdempotencyKeyInProgress.New(nil).WithRetryAfter(time.Second)
+		}
+		return nil, NewAPIErrorFromCode(ErrorCodeUnspecified, err)
+	}
+	if stored.Fingerprint != fingerprint {
+		return nil, ErrorCodeIdempotencyKeyReused.New(nil)
+	}
+	if stored.State == IdempotencyKeyStateCompleted {
+		header := http.Header{}
+		for key, values := range stored.Header {
+			header[key] = values
+		}
+		header.Set(IdempotentReplayedHeader, "true")
+		return &RenderedResponse{
+			Status:      stored.Status,
+			Header:      header,
+			ContentType: stored.ContentType,
+			Body:        stored.Body,
+		}, nil
+	}
+
+	// lock of a request which never completed is taken over
+	err = col.Update(
+		bson.M{"_id": key, "state": IdempotencyKeyStateProcessing, "lockedUntil": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"lockedUntil": now.Add(i.LockTimeout)}},
+	)
+	if err == mgo.ErrNotFound {
+		return nil, ErrorCodeIdempotencyKeyInProgress.New(nil).WithRetryAfter(time.Second)
+	}
+	if err != nil {
+		return nil, NewAPIErrorFromCode(ErrorCodeUnspecified, err)
+	}
+	return nil, nil
+}
+
+// complete stores resp of key, key of a response which asks the caller to retry is released instead
+func (i *Idempotency) complete(req RenderRequest, key string, resp RenderedResponse) {
+	if isRetryableResponse(resp) {
+		i.release(req, key)
+		return
+	}
+
+	session := i.session.Copy()
+	defer session.Close()
+	err := session.DB(i.dbName).C(IdempotencyKeyCollectionName).UpdateId(key, bson.M{"$set": bson.M{
+		"state":       IdempotencyKeyStateCompleted,
+		"status":      resp.Status,
+		"header":      resp.Header,
+		"contentType": resp.ContentType,
+		"body":        resp.Body,
+	}})
+	if err != nil {
+		logf(req.RequestID(), "[Idempotency] failed to store response of key %q due to error: %v", key, err)
+	}
+}
+
+// isRetryableResponse reports whether resp is 5xx, 408, 429 or carries Retry-After, replaying it would keep
+// the retry from ever succeeding
+func isRetryableResponse(resp RenderedResponse) bool {
+	switch {
+	case resp.Status >= http.StatusInternalServerError,
+		resp.Status == http.StatusRequestTimeout,
+		resp.Status == http.StatusTooManyRequests:
+		return true
+	}
+	return resp.Header != nil && resp.Header.Get("Retry-After") != ""
+}
+
+// release removes lock of key so the request can be retried
+func (i *Idempotency) release(req RenderRequest, key string) {
+	session := i.session.Copy()
+	defer session.Close()
+	err := session.DB(i.dbName).C(IdempotencyKeyCollectionName).Remove(bson.M{"_id": key, "state": IdempotencyKeyStateProcessing})
+	if err != nil && err != mgo.ErrNotFound {
+		logf(req.RequestID(), "[Idempotency] failed to release key %q due to error: %v", key, err)
+	}
+}
+
+// idempotencyFingerprint is sha256 of method, path and payloads of the request
+func idempotencyFingerprint(method string, path string, payloads ...[]byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method + " " + path + "\n"))
+	for _, payload := range payloads {
+		hash.Write(payload)
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// headerChanges returns headers of after which are not in before e.g. headers set by the handler,
+// Content-Type is excluded as it is kept separately
+func headerChanges(before, after http.Header) http.Header {
+	changes := http.Header{}
+	for key, values := range after {
+		if key == "Content-Type" || reflect.DeepEqual(before[key], values) {
+			continue
+		}
+		changes[key] = values
+	}
+	return changes
+}
+
+// idempotencyRecorder passes response through and keeps a copy of its status and body
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *idempotencyRecorder) WriteHeader(statusCode int) {
+	if r.statusCode == 0 {
+		r.statusCode = statusCode
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *idempotencyRecorder) Write(data []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *idempotencyRecorder) status() int {
+	if r.statusCode == 0 {
+		return http.StatusOK
+	}
+	return r.statusCode
+}
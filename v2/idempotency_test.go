@@ -0,0 +1,116 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyScopedKey(t *testing.T) {
+	i := &Idempotency{Scope: IdempotencyScopeByCaller}
+	scopedKey := func(header string, value string, remoteAddr string) string {
+		req := httptest.NewRequest(http.MethodPost, "/orders", nil)
+		req.RemoteAddr = remoteAddr
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		key, err := i.scopedKey(httpRenderRequest{req: req}, "order-1")
+		if err != nil {
+			t.Fatalf("scopedKey() error: %v", err)
+		}
+		return key
+	}
+
+	alice := scopedKey("Authorization", "Bearer alice", "10.0.0.1:1000")
+	if alice != scopedKey("Authorization", "Bearer alice", "10.0.0.2:2000") {
+		t.Errorf("same caller from another address is expected to get the same key")
+	}
+	if alice == scopedKey("Authorization", "Bearer bob", "10.0.0.1:1000") {
+		t.Errorf("callers with different credentials are expected to get different keys")
+	}
+	if alice == scopedKey(DefaultAPIKeyHeader, "Bearer alice", "10.0.0.1:1000") {
+		t.Errorf("api key and authorization are expected to be different scopes")
+	}
+	if scopedKey("", "", "10.0.0.1:1000") == scopedKey("", "", "10.0.0.2:1000") {
+		t.Errorf("anonymous callers from different addresses are expected to get different keys")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	if _, err := i.scopedKey(httpRenderRequest{req: req}, "order 1"); err == nil || err.ErrorID != ErrorCodeInvalidIdempotencyKey.ID {
+		t.Errorf("key with space: error %v, want %v", err, ErrorCodeInvalidIdempotencyKey.Code)
+	}
+}
+
+func TestIdempotencyUncompressedResponse(t *testing.T) {
+	for _, encoding := range []string{"gzip", "deflate"} {
+		body, err := compressBody(encoding, []byte(`{"success":true}`))
+		if err != nil {
+			t.Fatalf("compressBody(%v) error: %v", encoding, err)
+		}
+		header := http.Header{}
+		header.Set("Content-Encoding", encoding)
+		header.Set("ETag", `W/"a"`)
+		header.Set("Vary", "Accept-Encoding")
+		header.Set("Location", "/orders/1")
+
+		resp, err := uncompressedResponse(RenderedResponse{Status: http.StatusCreated, Header: header, Body: body})
+		if err != nil {
+			t.Fatalf("uncompressedResponse(%v) error: %v", encoding, err)
+		}
+		if string(resp.Body) != `{"success":true}` {
+			t.Errorf("%v: body %q", encoding, resp.Body)
+		}
+		for _, key := range []string{"Content-Encoding", "ETag", "Vary"} {
+			if resp.Header.Get(key) != "" {
+				t.Errorf("%v: header %v is expected to be removed", encoding, key)
+			}
+		}
+		if resp.Header.Get("Location") != "/orders/1" {
+			t.Errorf("%v: Location header is expected to be kept", encoding)
+		}
+	}
+
+	if _, err := uncompressedResponse(RenderedResponse{Header: http.Header{"Content-Encoding": {"br"}}}); err == nil {
+		t.Errorf("unsupported encoding is expected to fail")
+	}
+}
+
+func TestIdempotencyReleasesRetryableResponses(t *testing.T) {
+	req := httpRenderRequest{req: httptest.NewRequest(http.MethodPost, "/orders", nil)}
+	retryAfter := http.Header{}
+	retryAfter.Set("Retry-After", "1")
+	cases := []struct {
+		name string
+		resp RenderedResponse
+		want bool
+	}{
+		{"created", RenderedResponse{Status: http.StatusCreated}, false},
+		{"bad request", RenderedResponse{Status: http.StatusBadRequest}, false},
+		{"request timeout", RenderedResponse{Status: http.StatusRequestTimeout}, true},
+		{"too many requests", RenderedResponse{Status: http.StatusTooManyRequests}, true},
+		{"conflict with Retry-After", RenderedResponse{Status: http.StatusConflict, Header: retryAfter}, true},
+		{"server error", RenderedResponse{Status: http.StatusServiceUnavailable}, true},
+		{"rate limited by RateLimiter", RenderError(req, ErrorCodeRateLimited.New(nil).WithRetryAfter(time.Second)), true},
+	}
+	for _, c := range cases {
+		if got := isRetryableResponse(c.resp); got != c.want {
+			t.Errorf("%v: isRetryableResponse() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestIdempotencyMiddlewareRejectsLargeBody(t *testing.T) {
+	reached := false
+	handler := (&Idempotency{}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		reached = true
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(strings.Repeat("a", int(DefaultMaxBodySize)+1)))
+	req.Header.Set(IdempotencyKeyHeader, "order-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if reached || w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %v, reached handler %v, want 413 without reaching handler", w.Code, reached)
+	}
+}
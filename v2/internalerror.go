@@ -0,0 +1,69 @@
+package core
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+)
+
+// InternalTokenHeader is request header trusted callers send with value of "api.error.trusted_token"
+// to receive internal error details in production
+const InternalTokenHeader = "X-Internal-Token"
+
+// ShowInternalErrors opts in to send internal error messages to every caller and to capture stack of APIError,
+// e.g. in development. Internal errors are hidden by default, revel config "api.error.hide_internal" overrides it.
+var ShowInternalErrors = false
+
+// hideInternalErrors returns "api.error.hide_internal", default is true unless ShowInternalErrors is set
+func hideInternalErrors() bool {
+	return configBool("api.error.hide_internal", !ShowInternalErrors)
+}
+
+// hideInternalError returns copy of err without internal details when they must be hidden from the caller.
+// The details are logged with a correlation id which is returned to the caller instead.
+func hideInternalError(req RenderRequest, err *APIError) *APIError {
+	if !hideInternalErrors() || isTrustedCaller(req) {
+		return err
+	}
+	if err.InternalErrorMessage == "" && err.HTTPStatus < http.StatusInternalServerError {
+		return err
+	}
+
+	hidden := *err
+	if hidden.CorrelationID == "" {
+		hidden.CorrelationID, _ = NewUUID()
+	}
+	hidden.InternalErrorMessage = ""
+	logf(req.RequestID(), "[Renderer] correlationId=%v status=%v id=%v message=%q internal=%q",
+		hidden.CorrelationID, err.HTTPStatus, err.ErrorID, err.Message, err.InternalErrorMessage)
+	return &hidden
+}
+
+// isTrustedCaller checks request against "api.error.trusted_networks" (comma separated ips or cidrs)
+// and "api.error.trusted_token"
+func isTrustedCaller(req RenderRequest) bool {
+	if token := configString("api.error.trusted_token", ""); token != "" {
+		given := req.Header(InternalTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return isTrustedAddr(req.RemoteAddr(), configStrings("api.error.trusted_networks", nil))
+}
+
+func isTrustedAddr(remoteAddr string, networks []string) bool {
+	ip := remoteIP(remoteAddr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if _, cidr, err := net.ParseCIDR(network); err == nil {
+			if cidr.Contains(ip) {
+				return true
+			}
+		} else if trusted := net.ParseIP(network); trusted != nil && trusted.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
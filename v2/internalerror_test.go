@@ -0,0 +1,41 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHideInternalError(t *testing.T) {
+	req := httpRenderRequest{req: httptest.NewRequest(http.MethodGet, "/users", nil)}
+	cases := []struct {
+		name         string
+		show         bool
+		status       int
+		internal     string
+		wantInternal string
+	}{
+		{"hidden by default", false, http.StatusInternalServerError, "dial tcp db-1:27017", ""},
+		{"hidden on 4xx", false, http.StatusBadRequest, "dial tcp db-1:27017", ""},
+		{"shown when opted in", true, http.StatusInternalServerError, "dial tcp db-1:27017", "dial tcp db-1:27017"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ShowInternalErrors = c.show
+			defer func() { ShowInternalErrors = false }()
+
+			err := NewAPIError(1, c.status, "failed", errors.New(c.internal))
+			got := hideInternalError(req, err)
+			if got.InternalErrorMessage != c.wantInternal {
+				t.Errorf("internal message %q, want %q", got.InternalErrorMessage, c.wantInternal)
+			}
+			if got.InternalErrorMessage == "" && got.CorrelationID == "" {
+				t.Errorf("hidden error is expected to carry correlation id")
+			}
+			if hasStack := err.Stack() != ""; hasStack != c.show {
+				t.Errorf("stack captured %v, want %v", hasStack, c.show)
+			}
+		})
+	}
+}
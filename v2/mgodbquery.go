@@ -1,6 +1,10 @@
 package core
 
-import "gopkg.in/mgo.v2/bson"
+import (
+	"fmt"
+
+	"gopkg.in/mgo.v2/bson"
+)
 
 // MgoDBQuery ...
 type MgoDBQuery struct {
@@ -8,9 +12,94 @@ type MgoDBQuery struct {
 	Limit  int `json:"limit"`
 }
 
+// DateBucket is granularity used by CountGroupBy to group documents by a date field
+type DateBucket string
+
+const (
+	DateBucketNone  DateBucket = ""
+	DateBucketHour  DateBucket = "hour"
+	DateBucketDay   DateBucket = "day"
+	DateBucketMonth DateBucket = "month"
+	DateBucketYear  DateBucket = "year"
+)
+
+var dateBucketFormats = map[DateBucket]string{
+	DateBucketHour:  "%Y-%m-%dT%H",
+	DateBucketDay:   "%Y-%m-%d",
+	DateBucketMonth: "%Y-%m",
+	DateBucketYear:  "%Y",
+}
+
 func CountAnyCollectionWithQuery(collectionName string, query bson.M) (int, error) {
 	db := MgoDb{}
 	db.InitByRevelConfig()
 	defer db.Close()
 	return db.C(collectionName).Find(query).Count()
 }
+
+// CountFilters counts documents matching each named filter with a single aggregation on the current session
+// e.g. {"active": {"status": "active"}, "banned": {"status": "banned"}} => {"active": 10, "banned": 2}
+func (mgoDb *MgoDb) CountFilters(collectionName string, filters map[string]bson.M) (map[string]int, error) {
+	counts := map[string]int{}
+	if len(filters) == 0 {
+		return counts, nil
+	}
+
+	facets := map[string]*Pipeline{}
+	for name, filter := range filters {
+		facets[name] = NewPipeline().Match(filter).Count("count")
+	}
+
+	result := map[string][]struct {
+		Count int `bson:"count"`
+	}{}
+	err := NewPipeline().Facet(facets).One(mgoDb.C(collectionName), &result)
+	if err != nil {
+		return nil, err
+	}
+	for name := range filters {
+		counts[name] = 0
+		if len(result[name]) > 0 {
+			counts[name] = result[name][0].Count
+		}
+	}
+	return counts, nil
+}
+
+// CountGroupBy counts documents matching query grouped by value of field with a single aggregation on the current session.
+// When bucket is not DateBucketNone, field must be a date and it is grouped by formatted date (UTC)
+// e.g. DateBucketDay => {"2020-08-01": 3, "2020-08-02": 5}
+func (mgoDb *MgoDb) CountGroupBy(collectionName string, query bson.M, field string, bucket DateBucket) (map[string]int, error) {
+	var groupID interface{} = FieldRef(field)
+	if bucket != DateBucketNone {
+		format, ok := dateBucketFormats[bucket]
+		if !ok {
+			return nil, fmt.Errorf("[MgoDb::CountGroupBy] unknown date bucket: %v", bucket)
+		}
+		groupID = bson.M{"$dateToString": bson.M{"format": format, "date": FieldRef(field)}}
+	}
+
+	pipeline := NewPipeline()
+	if len(query) > 0 {
+		pipeline.Match(query)
+	}
+	pipeline.Group(groupID, Sum("count", 1))
+
+	result := []struct {
+		ID    interface{} `bson:"_id"`
+		Count int         `bson:"count"`
+	}{}
+	if err := pipeline.All(mgoDb.C(collectionName), &result); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, group := range result {
+		key := "null"
+		if group.ID != nil {
+			key = fmt.Sprint(group.ID)
+		}
+		counts[key] += group.Count
+	}
+	return counts, nil
+}
@@ -1,9 +1,9 @@
 package core
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
-	"log"
 	"net"
 	"strings"
 
@@ -25,6 +25,10 @@ type MgoDb struct {
 	Db      *mgo.Database
 	Col     *mgo.Collection
 	GridFS  *mgo.GridFS
+	// RequestID is added to logs of operations, see RequestIDFilter
+	RequestID string
+
+	audit *AuditContext
 }
 
 // IsDup Helper function to verify that error is duplicated keys error or not
@@ -39,16 +43,16 @@ func (mgoDb *MgoDb) Init(host string, dbName string) *mgo.Session {
 	if mainSession == nil {
 		var err error
 		mongoDBHost := host
-		log.Println("[mongodb::Init] db host:", mongoDBHost)
+		mgoDb.logf("[mongodb::Init] db host: %v", mongoDBHost)
 
 		useSSL := strings.Contains(host, "ssl=true")
 		if useSSL {
-			log.Println("[mongodb::Init] use SSL")
+			mgoDb.logf("[mongodb::Init] use SSL")
 
 			// try to remove ssl option from host uri
 			host = strings.Replace(host, "&ssl=true", "", 1)
 			host = strings.Replace(host, "?ssl=true", "", 1)
-			log.Println("[mongodb::Init] clean up host uri:", host)
+			mgoDb.logf("[mongodb::Init] clean up host uri: %v", host)
 
 			// set tls config...
 			tlsConfig := &tls.Config{}
@@ -57,7 +61,7 @@ func (mgoDb *MgoDb) Init(host string, dbName string) *mgo.Session {
 			// dial...
 			dialInfo, err := mgo.ParseURL(host)
 			if err != nil {
-				log.Println("[mongodb::Init] failed to parse host uri")
+				mgoDb.logf("[mongodb::Init] failed to parse host uri")
 				panic(err)
 			}
 			dialInfo.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
@@ -74,7 +78,7 @@ func (mgoDb *MgoDb) Init(host string, dbName string) *mgo.Session {
 		}
 
 		if err != nil {
-			log.Println("[mongodb::Init] cannot connect to", mongoDBHost, "due to error:", err.Error())
+			mgoDb.logf("[mongodb::Init] cannot connect to %v due to error: %v", mongoDBHost, err.Error())
 			panic(err)
 		}
 
@@ -137,7 +141,7 @@ func (mgoDb *MgoDb) UploadFile(fileCollectionName string, fileName string, data
 		err := fmt.Errorf("[MongoDB::Uploadfile] failed to write filedue to error: %v", err)
 		return nil, 0, err
 	}
-	println("[MongoDB::Uploadfile] finished uploading file[", gfsFile.Id().(bson.ObjectId).Hex(), "] to database...", n, "bytes")
+	mgoDb.logf("[MongoDB::Uploadfile] finished uploading file[%v] to database... %v bytes", gfsFile.Id().(bson.ObjectId).Hex(), n)
 	err = gfsFile.Close()
 	if err != nil {
 		return nil, 0, fmt.Errorf("[MongoDB::UploadFile] failed to close file")
@@ -145,6 +149,48 @@ func (mgoDb *MgoDb) UploadFile(fileCollectionName string, fileName string, data
 	return gfsFile, n, nil
 }
 
+// Insert inserts docs into collection, writes are recorded into audit log when audit is enabled.
+// With audit, _id generated for a doc without one is set on the doc which must be a map or a pointer to struct.
+func (mgoDb *MgoDb) Insert(collection string, docs ...interface{}) error {
+	if mgoDb.audit == nil {
+		return mgoDb.C(collection).Insert(docs...)
+	}
+	return mgoDb.insertWithAudit(collection, docs...)
+}
+
+// UpdateID updates document which has _id equal to id, writes are recorded into audit log when audit is enabled
+func (mgoDb *MgoDb) UpdateID(collection string, id interface{}, update interface{}) error {
+	if mgoDb.audit == nil {
+		return mgoDb.C(collection).UpdateId(id, update)
+	}
+	return mgoDb.updateWithAudit(mgoDb.C(collection), bson.M{"_id": id}, update)
+}
+
+// RemoveID removes document which has _id equal to id, writes are recorded into audit log when audit is enabled
+func (mgoDb *MgoDb) RemoveID(collection string, id interface{}) error {
+	if mgoDb.audit == nil {
+		return mgoDb.C(collection).RemoveId(id)
+	}
+	return mgoDb.removeWithAudit(collection, id)
+}
+
+// WithRequestID sets RequestID to request id of controller c stored by RequestIDFilter, it returns mgoDb for chaining
+func (mgoDb *MgoDb) WithRequestID(c *revel.Controller) *MgoDb {
+	mgoDb.RequestID = RequestIDOfController(c)
+	return mgoDb
+}
+
+// WithRequestIDFromContext sets RequestID to request id of ctx stored by RequestIDMiddleware, it returns mgoDb for chaining
+func (mgoDb *MgoDb) WithRequestIDFromContext(ctx context.Context) *MgoDb {
+	mgoDb.RequestID = RequestIDFromContext(ctx)
+	return mgoDb
+}
+
+// logf logs message of an operation with request id of MgoDb
+func (mgoDb *MgoDb) logf(format string, args ...interface{}) {
+	logf(mgoDb.RequestID, format, args...)
+}
+
 // Close ...
 func (mgoDb *MgoDb) Close() bool {
 	defer mgoDb.Session.Close()
@@ -0,0 +1,29 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMgoDbWithRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	c := newTestController(httptest.NewRequest("GET", "/users", nil), nil)
+	c.Args[requestIDArgKey] = "req-revel"
+
+	(&MgoDb{}).WithRequestID(c).logf("[MgoDb::Test] %v", "revel")
+	(&MgoDb{}).WithRequestIDFromContext(ContextWithRequestID(context.Background(), "req-http")).logf("[MgoDb::Test] %v", "http")
+
+	for _, want := range []string{"[requestId=req-revel] [MgoDb::Test] revel", "[requestId=req-http] [MgoDb::Test] http"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log %q does not contain %q", buf.String(), want)
+		}
+	}
+}
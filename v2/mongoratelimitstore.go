@@ -0,0 +1,123 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// RateLimitCollectionName is the collection which MongoRateLimitStore keeps counters in
+const RateLimitCollectionName = "rate_limits"
+
+// rateLimitCounter is a document of rate_limits collection, one per key and window
+type rateLimitCounter struct {
+	ID       string    `bson:"_id"`
+	Key      string    `bson:"key"`
+	Count    int       `bson:"count"`
+	ExpireAt time.Time `bson:"expireAt"`
+}
+
+// MongoRateLimitStore is sliding window store shared by all instances of a service.
+// Requests are counted in fixed windows of the period, and count of previous window is weighted
+// by its overlap with the sliding window. Counters are removed by TTL index on expireAt.
+type MongoRateLimitStore struct {
+	session *mgo.Session
+	dbName  string
+}
+
+// NewMongoRateLimitStore creates store on a copy of session of mgoDb and ensures its TTL index
+func NewMongoRateLimitStore(mgoDb *MgoDb) (*MongoRateLimitStore, error) {
+	s := &MongoRateLimitStore{
+		session: mgoDb.Session.Copy(),
+		dbName:  mgoDb.Db.Name,
+	}
+	err := s.session.DB(s.dbName).C(RateLimitCollectionName).EnsureIndex(mgo.Index{
+		Key:         []string{"expireAt"},
+		ExpireAfter: time.Second,
+		Background:  true,
+	})
+	if err != nil {
+		s.session.Close()
+		return nil, fmt.Errorf("[MongoRateLimitStore] failed to ensure index due to error: %v", err)
+	}
+	return s, nil
+}
+
+// Close closes session of the store
+func (s *MongoRateLimitStore) Close() {
+	s.session.Close()
+}
+
+// Take counts a request of key in current window, the request is not counted when it is rejected
+func (s *MongoRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	session := s.session.Copy()
+	defer session.Close()
+	col := session.DB(s.dbName).C(RateLimitCollectionName)
+
+	now := time.Now()
+	windowStart := now.Truncate(limit.Period)
+	elapsed := now.Sub(windowStart)
+
+	current := rateLimitCounter{}
+	_, err := col.FindId(rateLimitCounterID(key, windowStart)).Apply(mgo.Change{
+		Update: bson.M{
+			"$inc": bson.M{"count": 1},
+			"$set": bson.M{"key": key, "expireAt": windowStart.Add(2 * limit.Period)},
+		},
+		Upsert:    true,
+		ReturnNew: true,
+	}, &current)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	previous := rateLimitCounter{}
+	err = col.FindId(rateLimitCounterID(key, windowStart.Add(-limit.Period))).One(&previous)
+	if err != nil && err != mgo.ErrNotFound {
+		return RateLimitResult{}, err
+	}
+
+	weight := 1 - elapsed.Seconds()/limit.Period.Seconds()
+	estimated := float64(previous.Count)*weight + float64(current.Count)
+
+	result := RateLimitResult{
+		Allowed: estimated <= float64(limit.Requests),
+		Limit:   limit.Requests,
+		Reset:   limit.Period - elapsed,
+	}
+	if result.Allowed {
+		result.Remaining = limit.Requests - int(math.Ceil(estimated))
+		return result, nil
+	}
+
+	err = col.UpdateId(current.ID, bson.M{"$inc": bson.M{"count": -1}})
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	result.RetryAfter = result.Reset
+	return result, nil
+}
+
+// Refund uncounts a request of key in current window
+func (s *MongoRateLimitStore) Refund(key string, limit RateLimit) error {
+	session := s.session.Copy()
+	defer session.Close()
+
+	windowStart := time.Now().Truncate(limit.Period)
+	err := session.DB(s.dbName).C(RateLimitCollectionName).Update(
+		bson.M{"_id": rateLimitCounterID(key, windowStart), "count": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"count": -1}},
+	)
+	if err == mgo.ErrNotFound {
+		// the window has just rolled over, the request is counted in previous window which expires soon
+		return nil
+	}
+	return err
+}
+
+func rateLimitCounterID(key string, windowStart time.Time) string {
+	return fmt.Sprintf("%s:%d", key, windowStart.Unix())
+}
@@ -0,0 +1,173 @@
+package core
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	MediaTypeJSON    = "application/json"
+	MediaTypeXML     = "application/xml"
+	MediaTypeMsgPack = "application/msgpack"
+)
+
+// ProblemXMLContentType is content type of RFC 7807 problem document in XML
+const ProblemXMLContentType = "application/problem+xml"
+
+var ErrorCodeNotAcceptable = RegisterErrorCode(ErrorCode{
+	ID:         9,
+	Code:       "not_acceptable",
+	HTTPStatus: 406,
+	Message:    "None of accepted media types is supported: %v",
+	MessageKey: "core.error.not_acceptable",
+})
+
+// mediaTypeAliases maps media types in Accept header into the media types renderer produces
+var mediaTypeAliases = map[string]string{
+	"application/json":          MediaTypeJSON,
+	"text/json":                 MediaTypeJSON,
+	ProblemJSONContentType:      MediaTypeJSON,
+	"application/xml":           MediaTypeXML,
+	"text/xml":                  MediaTypeXML,
+	ProblemXMLContentType:       MediaTypeXML,
+	"application/msgpack":       MediaTypeMsgPack,
+	"application/x-msgpack":     MediaTypeMsgPack,
+	"application/vnd.msgpack":   MediaTypeMsgPack,
+	"application/x-messagepack": MediaTypeMsgPack,
+}
+
+// supportedMediaTypes returns "api.render.media_types", default is all of json, xml and msgpack
+func supportedMediaTypes() []string {
+	return configStrings("api.render.media_types", []string{MediaTypeJSON, MediaTypeXML, MediaTypeMsgPack})
+}
+
+// defaultMediaType returns "api.render.default_media_type", it is used for */* and missing Accept header
+func defaultMediaType() string {
+	return configString("api.render.default_media_type", MediaTypeJSON)
+}
+
+type acceptedMediaRange struct {
+	mediaType string
+	quality   float64
+}
+
+// NegotiateMediaType picks the media type to render from Accept header, it returns false when nothing is acceptable
+func NegotiateMediaType(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return defaultMediaType(), true
+	}
+
+	ranges := []acceptedMediaRange{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality > 0 {
+			ranges = append(ranges, acceptedMediaRange{mediaType: mediaType, quality: quality})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].quality > ranges[j].quality })
+
+	supported := supportedMediaTypes()
+	for _, r := range ranges {
+		switch {
+		case r.mediaType == "*/*":
+			return defaultMediaType(), true
+		case strings.HasSuffix(r.mediaType, "/*"):
+			prefix := strings.TrimSuffix(r.mediaType, "*")
+			if strings.HasPrefix(defaultMediaType(), prefix) {
+				return defaultMediaType(), true
+			}
+			for _, mediaType := range supported {
+				if strings.HasPrefix(mediaType, prefix) {
+					return mediaType, true
+				}
+			}
+		default:
+			if mediaType, ok := mediaTypeAliases[r.mediaType]; ok && containsString(supported, mediaType) {
+				return mediaType, true
+			}
+		}
+	}
+	return "", false
+}
+
+// encodeResponseBody encodes v in mediaType and returns body with its content type,
+// ProblemDocument gets problem content types
+func encodeResponseBody(mediaType string, v interface{}) ([]byte, string, error) {
+	_, isProblem := v.(ProblemDocument)
+	switch mediaType {
+	case MediaTypeXML:
+		if isProblem {
+			body, err := encodeXML("problem", v)
+			return body, ProblemXMLContentType + "; charset=utf-8", err
+		}
+		body, err := encodeXML("response", v)
+		return body, MediaTypeXML + "; charset=utf-8", err
+	case MediaTypeMsgPack:
+		body, err := encodeMsgPack(v)
+		return body, MediaTypeMsgPack, err
+	}
+
+	var body []byte
+	var err error
+	if configBool("results.pretty", false) {
+		body, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		body, err = json.Marshal(v)
+	}
+	if isProblem {
+		return body, ProblemJSONContentType, err
+	}
+	return body, MediaTypeJSON + "; charset=utf-8", err
+}
+
+// renderNegotiated renders v in media type negotiated from Accept header of the request,
+// 406 error is rendered in default media type when nothing is acceptable
+func renderNegotiated(req RenderRequest, status int, v interface{}) RenderedResponse {
+	accept := req.Header("Accept")
+	mediaType, ok := NegotiateMediaType(accept)
+	if !ok {
+		mediaType = defaultMediaType()
+		status = http.StatusNotAcceptable
+		notAcceptable := NewAPIErrorFromCode(ErrorCodeNotAcceptable, nil, accept)
+		if problem, isProblem := v.(ProblemDocument); isProblem {
+			notAcceptableProblem := NewProblemDocument(notAcceptable, problem.Instance)
+			notAcceptableProblem.RequestID = problem.RequestID
+			v = notAcceptableProblem
+		} else {
+			v = toEnvelope(req, JSONResponse{Success: false, Error: notAcceptable, RequestID: req.RequestID()})
+		}
+	}
+
+	body, contentType, err := encodeResponseBody(mediaType, v)
+	if err != nil {
+		return renderFallback(err)
+	}
+	return RenderedResponse{
+		Status:      status,
+		Header:      http.Header{},
+		ContentType: contentType,
+		Body:        body,
+	}
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,319 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// OplogResumeTokenCollectionName is the collection which subscriptions persist their last seen oplog timestamp
+const OplogResumeTokenCollectionName = "oplog_resume_tokens"
+
+// DefaultOplogCommitInterval is how often timestamps committed to a Subscription are saved as resume token
+const DefaultOplogCommitInterval = time.Second
+
+// oplogTailTimeout is how long a tailing cursor waits for new entries before cancellation is checked again
+const oplogTailTimeout = time.Second
+
+// ChangeOperation is type of change delivered by Subscription
+type ChangeOperation string
+
+const (
+	ChangeOperationInsert ChangeOperation = "insert"
+	ChangeOperationUpdate ChangeOperation = "update"
+	ChangeOperationDelete ChangeOperation = "delete"
+)
+
+var oplogOperations = map[string]ChangeOperation{
+	"i": ChangeOperationInsert,
+	"u": ChangeOperationUpdate,
+	"d": ChangeOperationDelete,
+}
+
+// ChangeEvent is a change of a document in subscribed collection.
+// Document is the inserted document or the replacement of a replace update,
+// Update is the update modifier e.g. {"$set": {...}} of an update.
+type ChangeEvent struct {
+	Operation  ChangeOperation     `json:"operation"`
+	Database   string              `json:"database"`
+	Collection string              `json:"collection"`
+	DocumentID interface{}         `json:"documentId"`
+	Document   bson.M              `json:"document,omitempty"`
+	Update     bson.M              `json:"update,omitempty"`
+	Timestamp  bson.MongoTimestamp `json:"timestamp"`
+}
+
+// Decode decodes Document of the event into out
+func (e ChangeEvent) Decode(out interface{}) error {
+	if e.Document == nil {
+		return fmt.Errorf("[ChangeEvent] %v event of %v has no document", e.Operation, e.DocumentID)
+	}
+	data, err := bson.Marshal(e.Document)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, out)
+}
+
+// SubscribeOptions configures MgoDb.Subscribe
+type SubscribeOptions struct {
+	// Database to subscribe, default is database of MgoDb
+	Database   string
+	Collection string
+	// Filter is matched against "o" field of oplog entries which is the whole document for inserts,
+	// the update modifier or replacement for updates and only {_id} for deletes.
+	// Keys are field paths, top-level operators e.g. $or are not supported.
+	Filter bson.M
+	// ConsumerName with Database and Collection identifies the resume token,
+	// when it is empty the subscription starts from now on every restart
+	ConsumerName string
+	// CommitInterval is how often timestamp passed to Subscription.Commit is saved, default is DefaultOplogCommitInterval
+	CommitInterval time.Duration
+	// BufferSize of the events channel
+	BufferSize int
+}
+
+// Subscription delivers changes of a collection until its context is done
+type Subscription struct {
+	events chan ChangeEvent
+	err    error
+
+	mutex     sync.Mutex
+	committed bson.MongoTimestamp
+	// saved and savedAt are only accessed by the tailing goroutine
+	saved   bson.MongoTimestamp
+	savedAt time.Time
+}
+
+// Events returns channel of changes, it is closed when the subscription stops
+func (s *Subscription) Events() <-chan ChangeEvent {
+	return s.events
+}
+
+// Err returns error which stopped the subscription, it must be called after Events channel is closed
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+// Commit confirms that events up to timestamp are processed. The latest committed timestamp is saved
+// as resume token of the consumer every CommitInterval and when the subscription stops, so events which
+// are not committed are delivered again after restart. Commits made after Events channel is closed are not saved.
+func (s *Subscription) Commit(timestamp bson.MongoTimestamp) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if timestamp > s.committed {
+		s.committed = timestamp
+	}
+}
+
+// saveCommitted saves committed timestamp when it changed and interval has passed since the last save, force ignores interval
+func (s *Subscription) saveCommitted(session *mgo.Session, dbName string, opts SubscribeOptions, force bool) error {
+	s.mutex.Lock()
+	committed := s.committed
+	s.mutex.Unlock()
+	if committed <= s.saved || (!force && time.Since(s.savedAt) < opts.CommitInterval) {
+		return nil
+	}
+	if err := saveOplogResumeToken(session, dbName, opts, committed); err != nil {
+		return err
+	}
+	s.saved = committed
+	s.savedAt = time.Now()
+	return nil
+}
+
+type oplogEntry struct {
+	Timestamp bson.MongoTimestamp `bson:"ts"`
+	Operation string              `bson:"op"`
+	Namespace string              `bson:"ns"`
+	Object    bson.M              `bson:"o"`
+	Object2   bson.M              `bson:"o2"`
+}
+
+// Subscribe tails replica set oplog of a collection and delivers its changes as events.
+// mgo driver does not support change streams so the oplog is read directly, which requires
+// read access to "local" database. The subscription runs on a copy of current session.
+// Consumer confirms processed events with Subscription.Commit, only committed timestamps are resumed from.
+func (mgoDb *MgoDb) Subscribe(ctx context.Context, opts SubscribeOptions) (*Subscription, error) {
+	if opts.Database == "" {
+		opts.Database = mgoDb.Db.Name
+	}
+	if opts.Collection == "" {
+		return nil, fmt.Errorf("[MgoDb::Subscribe] collection must not be empty")
+	}
+	if opts.CommitInterval <= 0 {
+		opts.CommitInterval = DefaultOplogCommitInterval
+	}
+	for key := range opts.Filter {
+		if strings.HasPrefix(key, "$") {
+			return nil, fmt.Errorf("[MgoDb::Subscribe] filter operator %v is not supported, filter keys must be field paths", key)
+		}
+	}
+
+	session := mgoDb.Session.Copy()
+	lastTimestamp, err := loadOplogResumeToken(session, mgoDb.Db.Name, opts)
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	sub := &Subscription{
+		events:    make(chan ChangeEvent, opts.BufferSize),
+		committed: lastTimestamp,
+		saved:     lastTimestamp,
+		savedAt:   time.Now(),
+	}
+	// subscriber logs with request id of mgoDb, it is a copy so mgoDb can be reused while the subscription runs
+	subscriber := &MgoDb{Session: session, Db: session.DB(mgoDb.Db.Name), RequestID: mgoDb.RequestID}
+	go func() {
+		defer session.Close()
+		defer close(sub.events)
+		sub.err = subscriber.tailOplog(ctx, opts, lastTimestamp, sub)
+		if err := sub.saveCommitted(session, mgoDb.Db.Name, opts, true); err != nil && sub.err == nil {
+			sub.err = err
+		}
+	}()
+	return sub, nil
+}
+
+func (mgoDb *MgoDb) tailOplog(ctx context.Context, opts SubscribeOptions, lastTimestamp bson.MongoTimestamp, sub *Subscription) error {
+	session, dbName := mgoDb.Session, mgoDb.Db.Name
+	namespace := opts.Database + "." + opts.Collection
+	oplog := session.DB("local").C("oplog.rs")
+	tail := func() *mgo.Iter {
+		query := bson.M{
+			"ns": namespace,
+			"op": bson.M{"$in": []string{"i", "u", "d"}},
+			"ts": bson.M{"$gt": lastTimestamp},
+		}
+		for key, value := range opts.Filter {
+			query["o."+key] = value
+		}
+		return oplog.Find(query).LogReplay().Tail(oplogTailTimeout)
+	}
+
+	iter := tail()
+	defer func() { iter.Close() }()
+	for {
+		entry := oplogEntry{}
+		for iter.Next(&entry) {
+			select {
+			case sub.events <- newChangeEvent(entry):
+			case <-ctx.Done():
+				return nil
+			}
+			lastTimestamp = entry.Timestamp
+			if err := sub.saveCommitted(session, dbName, opts, false); err != nil {
+				return err
+			}
+			entry = oplogEntry{}
+		}
+		if err := iter.Err(); err != nil {
+			return fmt.Errorf("[MgoDb::Subscribe] failed to tail oplog of %v due to error: %v", namespace, err)
+		}
+		// tailing cursor returns at least every oplogTailTimeout so commits are saved while there is no change
+		if err := sub.saveCommitted(session, dbName, opts, false); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if iter.Timeout() {
+			continue
+		}
+		// cursor is dead e.g. the collection was empty when it was opened, reopen from last position
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(oplogTailTimeout):
+		}
+		mgoDb.logf("[MgoDb::Subscribe] reopen tailing cursor of %v", namespace)
+		iter.Close()
+		iter = tail()
+	}
+}
+
+func newChangeEvent(entry oplogEntry) ChangeEvent {
+	event := ChangeEvent{
+		Operation: oplogOperations[entry.Operation],
+		Timestamp: entry.Timestamp,
+	}
+	if i := strings.Index(entry.Namespace, "."); i >= 0 {
+		event.Database = entry.Namespace[:i]
+		event.Collection = entry.Namespace[i+1:]
+	}
+
+	switch event.Operation {
+	case ChangeOperationInsert:
+		event.DocumentID = entry.Object["_id"]
+		event.Document = entry.Object
+	case ChangeOperationUpdate:
+		event.DocumentID = entry.Object2["_id"]
+		if isUpdateModifier(entry.Object) {
+			event.Update = entry.Object
+		} else {
+			event.Document = entry.Object
+		}
+	case ChangeOperationDelete:
+		event.DocumentID = entry.Object["_id"]
+	}
+	return event
+}
+
+func isUpdateModifier(doc bson.M) bool {
+	for key := range doc {
+		if strings.HasPrefix(key, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+// oplogResumeTokenID returns id of resume token of the consumer of subscribed namespace,
+// so a consumer subscribing several collections keeps a position for each of them
+func oplogResumeTokenID(opts SubscribeOptions) string {
+	return opts.ConsumerName + "@" + opts.Database + "." + opts.Collection
+}
+
+// loadOplogResumeToken returns last timestamp seen by consumer, or the latest oplog timestamp when there is none
+func loadOplogResumeToken(session *mgo.Session, dbName string, opts SubscribeOptions) (bson.MongoTimestamp, error) {
+	if opts.ConsumerName != "" {
+		token := struct {
+			Timestamp bson.MongoTimestamp `bson:"ts"`
+		}{}
+		err := session.DB(dbName).C(OplogResumeTokenCollectionName).FindId(oplogResumeTokenID(opts)).One(&token)
+		if err == nil {
+			return token.Timestamp, nil
+		}
+		if err != mgo.ErrNotFound {
+			return 0, err
+		}
+	}
+
+	latest := oplogEntry{}
+	err := session.DB("local").C("oplog.rs").Find(nil).Sort("-$natural").One(&latest)
+	if err != nil && err != mgo.ErrNotFound {
+		return 0, err
+	}
+	return latest.Timestamp, nil
+}
+
+func saveOplogResumeToken(session *mgo.Session, dbName string, opts SubscribeOptions, timestamp bson.MongoTimestamp) error {
+	if opts.ConsumerName == "" {
+		return nil
+	}
+	_, err := session.DB(dbName).C(OplogResumeTokenCollectionName).UpsertId(oplogResumeTokenID(opts), bson.M{
+		"$set": bson.M{
+			"consumer":  opts.ConsumerName,
+			"namespace": opts.Database + "." + opts.Collection,
+			"ts":        timestamp,
+			"updatedAt": time.Now(),
+		},
+	})
+	return err
+}
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestSubscriptionCommit(t *testing.T) {
+	sub := &Subscription{committed: 10, saved: 10, savedAt: time.Now()}
+	opts := SubscribeOptions{CommitInterval: time.Hour}
+
+	sub.Commit(12)
+	sub.Commit(11)
+	if sub.committed != 12 {
+		t.Fatalf("committed %v, want 12 since commits never move backward", sub.committed)
+	}
+
+	if err := sub.saveCommitted(nil, "db", opts, false); err != nil {
+		t.Fatalf("saveCommitted() error: %v", err)
+	}
+	if sub.saved != 10 {
+		t.Fatalf("saved %v, want 10 before commit interval has passed", sub.saved)
+	}
+	if err := sub.saveCommitted(nil, "db", opts, true); err != nil {
+		t.Fatalf("saveCommitted() error: %v", err)
+	}
+	if sub.saved != 12 {
+		t.Fatalf("saved %v, want 12 when forced", sub.saved)
+	}
+}
+
+func TestNewChangeEvent(t *testing.T) {
+	cases := []struct {
+		name  string
+		entry oplogEntry
+		want  ChangeEvent
+	}{
+		{
+			name:  "insert",
+			entry: oplogEntry{Operation: "i", Namespace: "app.users", Object: bson.M{"_id": 1, "name": "a"}},
+			want:  ChangeEvent{Operation: ChangeOperationInsert, Database: "app", Collection: "users", DocumentID: 1, Document: bson.M{"_id": 1, "name": "a"}},
+		},
+		{
+			name:  "update modifier",
+			entry: oplogEntry{Operation: "u", Namespace: "app.users", Object: bson.M{"$set": bson.M{"name": "b"}}, Object2: bson.M{"_id": 1}},
+			want:  ChangeEvent{Operation: ChangeOperationUpdate, Database: "app", Collection: "users", DocumentID: 1, Update: bson.M{"$set": bson.M{"name": "b"}}},
+		},
+		{
+			name:  "replace",
+			entry: oplogEntry{Operation: "u", Namespace: "app.users", Object: bson.M{"_id": 1, "name": "c"}, Object2: bson.M{"_id": 1}},
+			want:  ChangeEvent{Operation: ChangeOperationUpdate, Database: "app", Collection: "users", DocumentID: 1, Document: bson.M{"_id": 1, "name": "c"}},
+		},
+		{
+			name:  "delete",
+			entry: oplogEntry{Operation: "d", Namespace: "app.users", Object: bson.M{"_id": 1}},
+			want:  ChangeEvent{Operation: ChangeOperationDelete, Database: "app", Collection: "users", DocumentID: 1},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := newChangeEvent(c.entry); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("newChangeEvent() = %#v, want %#v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestIsUpdateModifier(t *testing.T) {
+	cases := []struct {
+		name string
+		doc  bson.M
+		want bool
+	}{
+		{"empty", bson.M{}, false},
+		{"replacement", bson.M{"_id": 1, "name": "a"}, false},
+		{"set", bson.M{"$set": bson.M{"name": "a"}}, true},
+		{"several operators", bson.M{"$set": bson.M{"name": "a"}, "$unset": bson.M{"age": ""}}, true},
+		{"oplog version field", bson.M{"$v": 1, "$set": bson.M{"name": "a"}}, true},
+		{"dollar inside field name", bson.M{"price$": 1}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := isUpdateModifier(c.doc); got != c.want {
+				t.Fatalf("isUpdateModifier(%v) = %v, want %v", c.doc, got, c.want)
+			}
+		})
+	}
+}
+
+func TestSubscribeRejectsFilterOperators(t *testing.T) {
+	opts := SubscribeOptions{
+		Database:   "app",
+		Collection: "users",
+		Filter:     bson.M{"$or": []bson.M{{"name": "a"}, {"name": "b"}}},
+	}
+	_, err := (&MgoDb{}).Subscribe(context.Background(), opts)
+	if err == nil || !strings.Contains(err.Error(), "$or") {
+		t.Fatalf("Subscribe() error %v, want unsupported $or error", err)
+	}
+}
+
+func TestOplogResumeTokenID(t *testing.T) {
+	users := oplogResumeTokenID(SubscribeOptions{ConsumerName: "indexer", Database: "app", Collection: "users"})
+	orders := oplogResumeTokenID(SubscribeOptions{ConsumerName: "indexer", Database: "app", Collection: "orders"})
+	if users == orders {
+		t.Fatalf("resume token id %q is shared between collections", users)
+	}
+	if users != "indexer@app.users" {
+		t.Fatalf("resume token id %q, want indexer@app.users", users)
+	}
+}
@@ -0,0 +1,290 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// AccumulatorOperator is operator usable in $group stage
+type AccumulatorOperator string
+
+const (
+	AccumulatorSum      AccumulatorOperator = "$sum"
+	AccumulatorAvg      AccumulatorOperator = "$avg"
+	AccumulatorMin      AccumulatorOperator = "$min"
+	AccumulatorMax      AccumulatorOperator = "$max"
+	AccumulatorFirst    AccumulatorOperator = "$first"
+	AccumulatorLast     AccumulatorOperator = "$last"
+	AccumulatorPush     AccumulatorOperator = "$push"
+	AccumulatorAddToSet AccumulatorOperator = "$addToSet"
+)
+
+// Accumulator is an output field of $group stage e.g. Sum("total", FieldRef("amount"))
+type Accumulator struct {
+	Field      string
+	Operator   AccumulatorOperator
+	Expression interface{}
+}
+
+// FieldRef returns expression referencing field of input documents e.g. FieldRef("amount") => "$amount"
+func FieldRef(field string) string {
+	if strings.HasPrefix(field, "$") {
+		return field
+	}
+	return "$" + field
+}
+
+// Sum returns $sum accumulator, use Sum(field, 1) to count documents
+func Sum(field string, expression interface{}) Accumulator {
+	return Accumulator{Field: field, Operator: AccumulatorSum, Expression: expression}
+}
+
+// Avg returns $avg accumulator
+func Avg(field string, expression interface{}) Accumulator {
+	return Accumulator{Field: field, Operator: AccumulatorAvg, Expression: expression}
+}
+
+// Min returns $min accumulator
+func Min(field string, expression interface{}) Accumulator {
+	return Accumulator{Field: field, Operator: AccumulatorMin, Expression: expression}
+}
+
+// Max returns $max accumulator
+func Max(field string, expression interface{}) Accumulator {
+	return Accumulator{Field: field, Operator: AccumulatorMax, Expression: expression}
+}
+
+// First returns $first accumulator
+func First(field string, expression interface{}) Accumulator {
+	return Accumulator{Field: field, Operator: AccumulatorFirst, Expression: expression}
+}
+
+// Last returns $last accumulator
+func Last(field string, expression interface{}) Accumulator {
+	return Accumulator{Field: field, Operator: AccumulatorLast, Expression: expression}
+}
+
+// Push returns $push accumulator
+func Push(field string, expression interface{}) Accumulator {
+	return Accumulator{Field: field, Operator: AccumulatorPush, Expression: expression}
+}
+
+// AddToSet returns $addToSet accumulator
+func AddToSet(field string, expression interface{}) Accumulator {
+	return Accumulator{Field: field, Operator: AccumulatorAddToSet, Expression: expression}
+}
+
+// Pipeline is a builder of aggregation pipeline for mgo.Collection.Pipe.
+// Errors found while building are collected and returned by Stages, All, One and Explain.
+type Pipeline struct {
+	stages       []bson.M
+	errs         []error
+	allowDiskUse bool
+}
+
+// NewPipeline returns an empty pipeline
+func NewPipeline() *Pipeline {
+	return &Pipeline{stages: []bson.M{}}
+}
+
+// Match adds $match stage
+func (p *Pipeline) Match(query bson.M) *Pipeline {
+	return p.add("$match", query)
+}
+
+// Project adds $project stage
+func (p *Pipeline) Project(fields bson.M) *Pipeline {
+	for field := range fields {
+		p.validateField("$project", field, true)
+	}
+	return p.add("$project", fields)
+}
+
+// Group adds $group stage, id is group key expression e.g. FieldRef("status") or nil for the whole collection
+func (p *Pipeline) Group(id interface{}, accumulators ...Accumulator) *Pipeline {
+	group := bson.M{"_id": id}
+	for _, acc := range accumulators {
+		p.validateField("$group", acc.Field, false)
+		if acc.Field == "_id" {
+			p.errs = append(p.errs, fmt.Errorf("[Pipeline] $group: _id is reserved for group key"))
+		}
+		if _, ok := group[acc.Field]; ok && acc.Field != "_id" {
+			p.errs = append(p.errs, fmt.Errorf("[Pipeline] $group: duplicated field %q", acc.Field))
+		}
+		group[acc.Field] = bson.M{string(acc.Operator): acc.Expression}
+	}
+	return p.add("$group", group)
+}
+
+// Sort adds $sort stage, fields are in the same format as mgo.Query.Sort e.g. Sort("-createdAt", "name")
+func (p *Pipeline) Sort(fields ...string) *Pipeline {
+	if len(fields) == 0 {
+		p.errs = append(p.errs, fmt.Errorf("[Pipeline] $sort: at least one field is required"))
+	}
+	sort := bson.D{}
+	for _, field := range fields {
+		order := 1
+		if strings.HasPrefix(field, "-") {
+			order = -1
+			field = field[1:]
+		} else if strings.HasPrefix(field, "+") {
+			field = field[1:]
+		}
+		p.validateField("$sort", field, true)
+		sort = append(sort, bson.DocElem{Name: field, Value: order})
+	}
+	return p.add("$sort", sort)
+}
+
+// Skip adds $skip stage
+func (p *Pipeline) Skip(n int) *Pipeline {
+	if n < 0 {
+		p.errs = append(p.errs, fmt.Errorf("[Pipeline] $skip: must not be negative"))
+	}
+	return p.add("$skip", n)
+}
+
+// Limit adds $limit stage
+func (p *Pipeline) Limit(n int) *Pipeline {
+	if n <= 0 {
+		p.errs = append(p.errs, fmt.Errorf("[Pipeline] $limit: must be positive"))
+	}
+	return p.add("$limit", n)
+}
+
+// Paginate adds $skip and $limit stages from query, zero values are omitted
+func (p *Pipeline) Paginate(query MgoDBQuery) *Pipeline {
+	if query.Offset > 0 {
+		p.Skip(query.Offset)
+	}
+	if query.Limit > 0 {
+		p.Limit(query.Limit)
+	}
+	return p
+}
+
+// Lookup adds $lookup stage joining documents of collection from
+func (p *Pipeline) Lookup(from, localField, foreignField, as string) *Pipeline {
+	if from == "" {
+		p.errs = append(p.errs, fmt.Errorf("[Pipeline] $lookup: from must not be empty"))
+	}
+	p.validateField("$lookup", localField, true)
+	p.validateField("$lookup", foreignField, true)
+	p.validateField("$lookup", as, true)
+	return p.add("$lookup", bson.M{
+		"from":         from,
+		"localField":   localField,
+		"foreignField": foreignField,
+		"as":           as,
+	})
+}
+
+// Unwind adds $unwind stage of array field path
+func (p *Pipeline) Unwind(path string, preserveNullAndEmptyArrays bool) *Pipeline {
+	p.validateField("$unwind", strings.TrimPrefix(path, "$"), true)
+	return p.add("$unwind", bson.M{
+		"path":                       FieldRef(path),
+		"preserveNullAndEmptyArrays": preserveNullAndEmptyArrays,
+	})
+}
+
+// Facet adds $facet stage running each sub pipeline on the same input documents
+func (p *Pipeline) Facet(facets map[string]*Pipeline) *Pipeline {
+	facet := bson.M{}
+	for name, sub := range facets {
+		p.validateField("$facet", name, false)
+		stages, err := sub.Stages()
+		if err != nil {
+			p.errs = append(p.errs, fmt.Errorf("[Pipeline] $facet %q: %v", name, err))
+			continue
+		}
+		for _, stage := range stages {
+			if _, ok := stage["$facet"]; ok {
+				p.errs = append(p.errs, fmt.Errorf("[Pipeline] $facet %q: nested $facet is not allowed", name))
+			}
+		}
+		facet[name] = stages
+	}
+	return p.add("$facet", facet)
+}
+
+// Count adds $count stage writing number of documents into field
+func (p *Pipeline) Count(field string) *Pipeline {
+	p.validateField("$count", field, false)
+	return p.add("$count", field)
+}
+
+// AllowDiskUse enables writing temporary files when running the pipeline
+func (p *Pipeline) AllowDiskUse() *Pipeline {
+	p.allowDiskUse = true
+	return p
+}
+
+// Stages returns built pipeline or the first error found while building
+func (p *Pipeline) Stages() ([]bson.M, error) {
+	if len(p.errs) > 0 {
+		return nil, p.errs[0]
+	}
+	return p.stages, nil
+}
+
+// Pipe prepares mgo.Pipe of the pipeline on collection c
+func (p *Pipeline) Pipe(c *mgo.Collection) (*mgo.Pipe, error) {
+	stages, err := p.Stages()
+	if err != nil {
+		return nil, err
+	}
+	pipe := c.Pipe(stages)
+	if p.allowDiskUse {
+		pipe = pipe.AllowDiskUse()
+	}
+	return pipe, nil
+}
+
+// All runs the pipeline on collection c and decodes all results into result, which must be pointer to slice
+func (p *Pipeline) All(c *mgo.Collection, result interface{}) error {
+	pipe, err := p.Pipe(c)
+	if err != nil {
+		return err
+	}
+	return pipe.All(result)
+}
+
+// One runs the pipeline on collection c and decodes the first result into result
+func (p *Pipeline) One(c *mgo.Collection, result interface{}) error {
+	pipe, err := p.Pipe(c)
+	if err != nil {
+		return err
+	}
+	return pipe.One(result)
+}
+
+// Explain returns query plan of the pipeline on collection c, for debugging
+func (p *Pipeline) Explain(c *mgo.Collection) (bson.M, error) {
+	pipe, err := p.Pipe(c)
+	if err != nil {
+		return nil, err
+	}
+	result := bson.M{}
+	err = pipe.Explain(&result)
+	return result, err
+}
+
+func (p *Pipeline) add(operator string, value interface{}) *Pipeline {
+	p.stages = append(p.stages, bson.M{operator: value})
+	return p
+}
+
+// validateField checks output field name, dotted paths are accepted only where MongoDB accepts them
+func (p *Pipeline) validateField(stage string, field string, allowDotted bool) {
+	if field == "" {
+		p.errs = append(p.errs, fmt.Errorf("[Pipeline] %s: field must not be empty", stage))
+	} else if strings.HasPrefix(field, "$") {
+		p.errs = append(p.errs, fmt.Errorf("[Pipeline] %s: field %q must not start with $", stage, field))
+	} else if !allowDotted && strings.Contains(field, ".") {
+		p.errs = append(p.errs, fmt.Errorf("[Pipeline] %s: field %q must not contain .", stage, field))
+	}
+}
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestPipelineStages(t *testing.T) {
+	cases := []struct {
+		name  string
+		build func(p *Pipeline) *Pipeline
+		want  []bson.M
+	}{
+		{
+			name:  "empty",
+			build: func(p *Pipeline) *Pipeline { return p },
+			want:  []bson.M{},
+		},
+		{
+			name: "match group sort",
+			build: func(p *Pipeline) *Pipeline {
+				return p.Match(bson.M{"status": "paid"}).
+					Group(FieldRef("customer"), Sum("total", FieldRef("amount")), Sum("count", 1)).
+					Sort("-total", "+_id")
+			},
+			want: []bson.M{
+				{"$match": bson.M{"status": "paid"}},
+				{"$group": bson.M{
+					"_id":   "$customer",
+					"total": bson.M{"$sum": "$amount"},
+					"count": bson.M{"$sum": 1},
+				}},
+				{"$sort": bson.D{{Name: "total", Value: -1}, {Name: "_id", Value: 1}}},
+			},
+		},
+		{
+			name: "paginate omits zero values",
+			build: func(p *Pipeline) *Pipeline {
+				return p.Paginate(MgoDBQuery{Limit: 10})
+			},
+			want: []bson.M{{"$limit": 10}},
+		},
+		{
+			name: "lookup unwind count",
+			build: func(p *Pipeline) *Pipeline {
+				return p.Lookup("users", "userId", "_id", "user").Unwind("user", true).Count("n")
+			},
+			want: []bson.M{
+				{"$lookup": bson.M{"from": "users", "localField": "userId", "foreignField": "_id", "as": "user"}},
+				{"$unwind": bson.M{"path": "$user", "preserveNullAndEmptyArrays": true}},
+				{"$count": "n"},
+			},
+		},
+		{
+			name: "facet",
+			build: func(p *Pipeline) *Pipeline {
+				return p.Facet(map[string]*Pipeline{"total": NewPipeline().Count("n")})
+			},
+			want: []bson.M{{"$facet": bson.M{"total": []bson.M{{"$count": "n"}}}}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := c.build(NewPipeline()).Stages()
+			if err != nil {
+				t.Fatalf("Stages() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("Stages() = %#v, want %#v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestPipelineStagesErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		build func(p *Pipeline) *Pipeline
+		want  string
+	}{
+		{"sort without fields", func(p *Pipeline) *Pipeline { return p.Sort() }, "$sort: at least one field is required"},
+		{"sort by operator", func(p *Pipeline) *Pipeline { return p.Sort("-$total") }, `field "$total" must not start with $`},
+		{"negative skip", func(p *Pipeline) *Pipeline { return p.Skip(-1) }, "$skip: must not be negative"},
+		{"zero limit", func(p *Pipeline) *Pipeline { return p.Limit(0) }, "$limit: must be positive"},
+		{"group _id accumulator", func(p *Pipeline) *Pipeline { return p.Group(nil, Sum("_id", 1)) }, "_id is reserved"},
+		{"group duplicated field", func(p *Pipeline) *Pipeline {
+			return p.Group(nil, Sum("n", 1), Max("n", "$a"))
+		}, `duplicated field "n"`},
+		{"group dotted field", func(p *Pipeline) *Pipeline { return p.Group(nil, Sum("a.b", 1)) }, `field "a.b" must not contain .`},
+		{"lookup without from", func(p *Pipeline) *Pipeline { return p.Lookup("", "a", "b", "c") }, "from must not be empty"},
+		{"empty count field", func(p *Pipeline) *Pipeline { return p.Count("") }, "$count: field must not be empty"},
+		{"invalid facet", func(p *Pipeline) *Pipeline {
+			return p.Facet(map[string]*Pipeline{"a": NewPipeline().Limit(0)})
+		}, `$facet "a": [Pipeline] $limit`},
+		{"nested facet", func(p *Pipeline) *Pipeline {
+			return p.Facet(map[string]*Pipeline{"a": NewPipeline().Facet(map[string]*Pipeline{})})
+		}, "nested $facet is not allowed"},
+		{"first error is returned", func(p *Pipeline) *Pipeline { return p.Skip(-1).Limit(0) }, "$skip"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			stages, err := c.build(NewPipeline()).Stages()
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("Stages() error %v, want %q", err, c.want)
+			}
+			if stages != nil {
+				t.Fatalf("Stages() = %#v, want nil on error", stages)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ProblemJSONContentType is content type of RFC 7807 problem document
+const ProblemJSONContentType = "application/problem+json"
+
+// ErrorFormat is output format of errors rendered by RevelResultRenderer
+type ErrorFormat string
+
+const (
+	// ErrorFormatEnvelope renders errors in JSONResponse, this is the default
+	ErrorFormatEnvelope ErrorFormat = "envelope"
+	// ErrorFormatProblem renders errors as RFC 7807 problem document
+	ErrorFormatProblem ErrorFormat = "problem"
+)
+
+// ProblemDocument is RFC 7807 representation of APIError, ErrorID and Code are extension members
+type ProblemDocument struct {
+	Type                 string       `json:"type"`
+	Title                string       `json:"title"`
+	Status               int          `json:"status"`
+	Detail               string       `json:"detail,omitempty"`
+	Instance             string       `json:"instance,omitempty"`
+	ErrorID              int          `json:"errorId"`
+	Code                 string       `json:"code,omitempty"`
+	InternalErrorMessage string       `json:"internalErrorMessage,omitempty"`
+	CorrelationID        string       `json:"correlationId,omitempty"`
+	Fields               []FieldError `json:"fields,omitempty"`
+	RequestID            string       `json:"requestId,omitempty"`
+}
+
+// NewProblemDocument converts err into problem document, type is docs url of the error code when it is registered
+func NewProblemDocument(err *APIError, instance string) ProblemDocument {
+	problemType := "about:blank"
+	if code, ok := LookupErrorCode(err.ErrorID); ok && code.DocsURL != "" {
+		problemType = code.DocsURL
+	}
+	return ProblemDocument{
+		Type:                 problemType,
+		Title:                http.StatusText(err.HTTPStatus),
+		Status:               err.HTTPStatus,
+		Detail:               err.Message,
+		Instance:             instance,
+		ErrorID:              err.ErrorID,
+		Code:                 err.Code,
+		InternalErrorMessage: err.InternalErrorMessage,
+		CorrelationID:        err.CorrelationID,
+		Fields:               err.Fields,
+	}
+}
+
+// APIError converts problem document decoded from a response back into APIError
+func (p ProblemDocument) APIError() *APIError {
+	return &APIError{
+		ErrorID:              p.ErrorID,
+		Code:                 p.Code,
+		HTTPStatus:           p.Status,
+		Message:              p.Detail,
+		InternalErrorMessage: p.InternalErrorMessage,
+		CorrelationID:        p.CorrelationID,
+		Fields:               p.Fields,
+	}
+}
+
+// errorFormat returns ErrorFormatProblem when client accepts application/problem+json or application/problem+xml,
+// otherwise the format configured by "api.error.format"
+func errorFormat(req RenderRequest) ErrorFormat {
+	accept := req.Header("Accept")
+	if strings.Contains(accept, ProblemJSONContentType) || strings.Contains(accept, ProblemXMLContentType) {
+		return ErrorFormatProblem
+	}
+	return ErrorFormat(configString("api.error.format", string(ErrorFormatEnvelope)))
+}
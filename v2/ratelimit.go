@@ -0,0 +1,299 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/revel/revel"
+)
+
+// DefaultAPIKeyHeader is header API key is read from by RateLimitByAPIKey
+const DefaultAPIKeyHeader = "X-API-Key"
+
+var (
+	ErrorCodeRateLimited = RegisterErrorCode(ErrorCode{
+		ID:         10,
+		Code:       "rate_limited",
+		HTTPStatus: 429,
+		Message:    "Too many requests",
+		MessageKey: "core.error.rate_limited",
+	})
+)
+
+// RateLimit allows Requests requests in every Period
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// RateLimitResult is state of a limit after a request is taken
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is time until the limit is fully available again
+	Reset time.Duration
+	// RetryAfter is time until next request is allowed, it is zero when Allowed
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps usage of rate limit keys, implementations must be safe for concurrent use
+type RateLimitStore interface {
+	// Take consumes one request of key under limit, a rejected request is not consumed
+	Take(key string, limit RateLimit) (RateLimitResult, error)
+	// Refund gives back a request taken from key, it is called when another rule rejects the request
+	Refund(key string, limit RateLimit) error
+}
+
+// RateLimitRule limits requests sharing the same key, requests which key returns empty string are not limited
+type RateLimitRule struct {
+	Name  string
+	Limit RateLimit
+	Key   func(req RenderRequest) string
+}
+
+// RateLimitByIP limits requests of each client IP. Revel filter uses client IP resolved by revel
+// which honours "app.behind.proxy", net/http middleware uses RemoteAddr of the request.
+func RateLimitByIP(limit RateLimit) RateLimitRule {
+	return RateLimitRule{
+		Name:  "ip",
+		Limit: limit,
+		Key: func(req RenderRequest) string {
+			if ip := remoteIP(req.RemoteAddr()); ip != nil {
+				return ip.String()
+			}
+			return req.RemoteAddr()
+		},
+	}
+}
+
+// RateLimitByAPIKey limits requests of each API key in header, the key is hashed before it reaches the store
+func RateLimitByAPIKey(header string, limit RateLimit) RateLimitRule {
+	return RateLimitRule{
+		Name:  "apikey",
+		Limit: limit,
+		Key: func(req RenderRequest) string {
+			apiKey := req.Header(header)
+			if apiKey == "" {
+				return ""
+			}
+			sum := sha256.Sum256([]byte(apiKey))
+			return hex.EncodeToString(sum[:])
+		},
+	}
+}
+
+// RateLimiter rejects requests exceeding any of its rules with 429 APIError. Every response carries
+// X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset of the most restrictive rule.
+// When the store fails the request is allowed and the error is logged.
+type RateLimiter struct {
+	Store RateLimitStore
+	Rules []RateLimitRule
+}
+
+// NewRateLimiter creates rate limiter of rules backed by store, it panics when a rule has no positive limit
+func NewRateLimiter(store RateLimitStore, rules ...RateLimitRule) *RateLimiter {
+	if store == nil {
+		panic("[RateLimiter] store cannot be null")
+	}
+	for _, rule := range rules {
+		if rule.Limit.Requests <= 0 || rule.Limit.Period <= 0 {
+			panic(fmt.Errorf("[RateLimiter] rule %v must have positive requests and period, got %v in %v",
+				rule.Name, rule.Limit.Requests, rule.Limit.Period))
+		}
+	}
+	return &RateLimiter{Store: store, Rules: rules}
+}
+
+// NewRateLimiterByRevelConfig creates rate limiter with rules from revel config:
+// "api.ratelimit.ip.requests", "api.ratelimit.ip.period" (default 1m),
+// "api.ratelimit.apikey.requests", "api.ratelimit.apikey.period" (default 1m) and
+// "api.ratelimit.apikey.header" (default X-API-Key). A rule is enabled when its requests is greater than zero.
+func NewRateLimiterByRevelConfig(store RateLimitStore) *RateLimiter {
+	rules := []RateLimitRule{}
+	if requests := configInt("api.ratelimit.ip.requests", 0); requests > 0 {
+		rules = append(rules, RateLimitByIP(RateLimit{
+			Requests: requests,
+			Period:   configDuration("api.ratelimit.ip.period", time.Minute),
+		}))
+	}
+	if requests := configInt("api.ratelimit.apikey.requests", 0); requests > 0 {
+		rules = append(rules, RateLimitByAPIKey(configString("api.ratelimit.apikey.header", DefaultAPIKeyHeader), RateLimit{
+			Requests: requests,
+			Period:   configDuration("api.ratelimit.apikey.period", time.Minute),
+		}))
+	}
+	return NewRateLimiter(store, rules...)
+}
+
+// Filter is revel.Filter of the rate limiter e.g. revel.Filters = []revel.Filter{..., limiter.Filter, ...}
+func (l *RateLimiter) Filter(c *revel.Controller, fc []revel.Filter) {
+	req := newRevelRenderRequest(c)
+	headers, err := l.take(req)
+	for key := range headers {
+		c.Response.Out.Header().Set(key, headers.Get(key))
+	}
+	if err != nil {
+		renderer := NewRevelResultRenderer(c)
+		c.Result = renderer.RenderJSONError(err)
+		return
+	}
+	fc[0](c, fc[1:])
+}
+
+// Middleware is Filter for net/http
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		headers, err := l.take(httpRenderRequest{req: req})
+		for key := range headers {
+			w.Header().Set(key, headers.Get(key))
+		}
+		if err != nil {
+			renderer := NewHTTPResultRenderer(w, req)
+			renderer.RenderJSONError(err)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// take consumes a request of every rule, it returns rate limit headers and 429 error when any rule rejects.
+// Requests taken by other rules are refunded when the request is rejected, so rejected requests use up no limit.
+func (l *RateLimiter) take(req RenderRequest) (http.Header, *APIError) {
+	type takenRule struct {
+		key  string
+		rule RateLimitRule
+	}
+	var (
+		restrictive *RateLimitResult
+		retryAfter  time.Duration
+		rejected    bool
+		taken       []takenRule
+	)
+	for _, rule := range l.Rules {
+		key := rule.Key(req)
+		if key == "" {
+			continue
+		}
+		key = rule.Name + ":" + key
+		result, err := l.Store.Take(key, rule.Limit)
+		if err != nil {
+			logf(req.RequestID(), "[RateLimiter] failed to take %v rate limit due to error: %v", rule.Name, err)
+			continue
+		}
+		if result.Allowed {
+			taken = append(taken, takenRule{key: key, rule: rule})
+		} else {
+			rejected = true
+			if result.RetryAfter > retryAfter {
+				retryAfter = result.RetryAfter
+			}
+		}
+		if restrictive == nil || result.Remaining < restrictive.Remaining {
+			r := result
+			restrictive = &r
+		}
+	}
+
+	headers := http.Header{}
+	if restrictive != nil {
+		headers.Set("X-RateLimit-Limit", strconv.Itoa(restrictive.Limit))
+		headers.Set("X-RateLimit-Remaining", strconv.Itoa(restrictive.Remaining))
+		headers.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(restrictive.Reset.Seconds()))))
+	}
+	if !rejected {
+		return headers, nil
+	}
+	for _, t := range taken {
+		if err := l.Store.Refund(t.key, t.rule.Limit); err != nil {
+			logf(req.RequestID(), "[RateLimiter] failed to refund %v rate limit due to error: %v", t.rule.Name, err)
+		}
+	}
+	return headers, ErrorCodeRateLimited.New(nil).WithRetryAfter(retryAfter)
+}
+
+// MemoryRateLimitStore is token bucket store kept in memory of the process, buckets idle
+// longer than their period are removed. Use MongoRateLimitStore when the service has multiple instances.
+type MemoryRateLimitStore struct {
+	lock      sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	period  time.Duration
+}
+
+// NewMemoryRateLimitStore creates empty in-memory store
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:   map[string]*tokenBucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Take refills bucket of key by elapsed time then consumes a token from it
+func (s *MemoryRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	now := time.Now()
+	capacity := float64(limit.Requests)
+	rate := capacity / limit.Period.Seconds()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sweep(now)
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, updated: now}
+		s.buckets[key] = bucket
+	}
+	bucket.period = limit.Period
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.updated).Seconds()*rate)
+	bucket.updated = now
+
+	result := RateLimitResult{Limit: limit.Requests}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - bucket.tokens) / rate)
+	}
+	result.Remaining = int(bucket.tokens)
+	result.Reset = secondsToDuration((capacity - bucket.tokens) / rate)
+	return result, nil
+}
+
+// Refund puts a token back into bucket of key, the bucket never exceeds its capacity
+func (s *MemoryRateLimitStore) Refund(key string, limit RateLimit) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if bucket, ok := s.buckets[key]; ok {
+		bucket.tokens = math.Min(float64(limit.Requests), bucket.tokens+1)
+	}
+	return nil
+}
+
+// sweep removes buckets which have been refilled completely, at most once a minute
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, bucket := range s.buckets {
+		if now.Sub(bucket.updated) > bucket.period {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefundsOtherRules(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	limiter := NewRateLimiter(store,
+		RateLimitByIP(RateLimit{Requests: 3, Period: time.Hour}),
+		RateLimitByAPIKey(DefaultAPIKeyHeader, RateLimit{Requests: 1, Period: time.Hour}),
+	)
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.RemoteAddr = "10.0.0.1:1000"
+		req.Header.Set(DefaultAPIKeyHeader, "key-1")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	wantCodes := []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}
+	for i, want := range wantCodes {
+		if got := serve(); got != want {
+			t.Fatalf("request %v: status %v, want %v", i, got, want)
+		}
+	}
+	// only the allowed request is expected to be counted by the ip rule
+	if tokens := int(store.buckets["ip:10.0.0.1"].tokens); tokens != 2 {
+		t.Fatalf("ip bucket has %v tokens, want 2", tokens)
+	}
+}
+
+func TestNewRateLimiterRejectsInvalidLimit(t *testing.T) {
+	cases := []RateLimit{
+		{Requests: 0, Period: time.Minute},
+		{Requests: -1, Period: time.Minute},
+		{Requests: 10, Period: 0},
+	}
+	for _, limit := range cases {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewRateLimiter() with %+v is expected to panic", limit)
+				}
+			}()
+			NewRateLimiter(NewMemoryRateLimitStore(), RateLimitByIP(limit))
+		}()
+	}
+}
+
+func TestRateLimitByIPUsesRevelClientIP(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/users", nil)
+	r.RemoteAddr = "10.0.0.1:1000"
+	c := newTestController(r, nil)
+
+	rule := RateLimitByIP(RateLimit{Requests: 1, Period: time.Minute})
+	if key := rule.Key(newRevelRenderRequest(c)); key != "10.0.0.1" {
+		t.Errorf("key %q without client ip, want address of the connection", key)
+	}
+	c.ClientIP = "203.0.113.5"
+	if key := rule.Key(newRevelRenderRequest(c)); key != "203.0.113.5" {
+		t.Errorf("key %q, want client ip resolved by revel", key)
+	}
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+
+	"github.com/revel/revel"
+)
+
+// PanicRecoveryFilter recovers panics of the rest of filter chain and renders them as JSONResponse
+// instead of revel's HTML error page. Panic with *APIError is rendered with its status, any other
+// panic becomes 500 APIError and its stack is logged. It should be placed right after RequestIDFilter
+// so the logged stack is tagged with request id.
+func PanicRecoveryFilter(c *revel.Controller, fc []revel.Filter) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			renderer := NewRevelResultRenderer(c)
+			c.Result = renderer.RenderJSONError(apiErrorFromPanic(RequestIDOfController(c), recovered))
+		}
+	}()
+	fc[0](c, fc[1:])
+}
+
+// PanicRecoveryMiddleware is PanicRecoveryFilter for net/http, http.ErrAbortHandler is panicked again
+// so the server can abort the response as usual. When the handler has already written the header,
+// the error cannot be rendered anymore so the panic is logged and the response is aborted.
+func PanicRecoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		recorder := &recoveryRecorder{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+			requestID := httpRenderRequest{req: req}.RequestID()
+			apiError := apiErrorFromPanic(requestID, recovered)
+			if recorder.wroteHeader {
+				logf(requestID, "[PanicRecovery] response is aborted since header was already written")
+				panic(http.ErrAbortHandler)
+			}
+			renderer := NewHTTPResultRenderer(w, req)
+			renderer.RenderJSONError(apiError)
+		}()
+		next.ServeHTTP(recorder, req)
+	})
+}
+
+// recoveryRecorder passes response through and remembers whether header has been written
+type recoveryRecorder struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (r *recoveryRecorder) WriteHeader(statusCode int) {
+	r.wroteHeader = true
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *recoveryRecorder) Write(data []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(data)
+}
+
+// Flush keeps streaming handlers working behind the middleware
+func (r *recoveryRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		r.wroteHeader = true
+		flusher.Flush()
+	}
+}
+
+// apiErrorFromPanic converts recovered value into APIError, stack is logged unless it is an APIError.
+// Typed nil errors e.g. panic((*APIError)(nil)) become 500 APIError without calling their Error method.
+func apiErrorFromPanic(requestID string, recovered interface{}) *APIError {
+	switch v := recovered.(type) {
+	case *APIError:
+		if v != nil {
+			logf(requestID, "[PanicRecovery] recovered APIError id=%v status=%v: %v", v.ErrorID, v.HTTPStatus, v.Error())
+			return v
+		}
+	case APIError:
+		logf(requestID, "[PanicRecovery] recovered APIError id=%v status=%v: %v", v.ErrorID, v.HTTPStatus, v.Error())
+		return &v
+	}
+
+	err, ok := recovered.(error)
+	if !ok {
+		err = fmt.Errorf("%v", recovered)
+	} else if value := reflect.ValueOf(err); value.Kind() == reflect.Ptr && value.IsNil() {
+		err = fmt.Errorf("panic with nil %T", recovered)
+	}
+	logf(requestID, "[PanicRecovery] recovered panic: %v\n%s", err, debug.Stack())
+	return NewAPIErrorFromCode(ErrorCodeUnspecified, err)
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/revel/revel"
+)
+
+type recoveryTestError struct{}
+
+func (*recoveryTestError) Error() string { return "recovery test error" }
+
+func TestPanicRecovery(t *testing.T) {
+	cases := []struct {
+		name       string
+		panicValue interface{}
+		wantStatus int
+		wantCode   string
+	}{
+		{"string", "boom", http.StatusInternalServerError, ErrorCodeUnspecified.Code},
+		{"error", errors.New("boom"), http.StatusInternalServerError, ErrorCodeUnspecified.Code},
+		{"APIError", NewAPIErrorFromCode(ErrorCodeNotFound, nil), http.StatusNotFound, ErrorCodeNotFound.Code},
+		{"APIError value", *NewAPIErrorFromCode(ErrorCodeNotFound, nil), http.StatusNotFound, ErrorCodeNotFound.Code},
+		{"typed nil APIError", (*APIError)(nil), http.StatusInternalServerError, ErrorCodeUnspecified.Code},
+		{"typed nil error", (*recoveryTestError)(nil), http.StatusInternalServerError, ErrorCodeUnspecified.Code},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			handler := PanicRecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				panic(c.panicValue)
+			}))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+			assertRecoveredResponse(t, "net/http", w, c.wantStatus, c.wantCode)
+
+			w = httptest.NewRecorder()
+			controller := newTestController(httptest.NewRequest(http.MethodGet, "/users/1", nil), w)
+			PanicRecoveryFilter(controller, []revel.Filter{func(c2 *revel.Controller, fc []revel.Filter) {
+				panic(c.panicValue)
+			}})
+			if controller.Result == nil {
+				t.Fatalf("revel: result is not set")
+			}
+			controller.Result.Apply(controller.Request, controller.Response)
+			assertRecoveredResponse(t, "revel", w, c.wantStatus, c.wantCode)
+		})
+	}
+}
+
+func assertRecoveredResponse(t *testing.T, adapter string, w *httptest.ResponseRecorder, wantStatus int, wantCode string) {
+	t.Helper()
+	if w.Code != wantStatus {
+		t.Errorf("%v: status %v, want %v", adapter, w.Code, wantStatus)
+	}
+	var resp struct {
+		Success bool
+		Error   struct{ Code string }
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%v: failed to decode body %s: %v", adapter, w.Body.String(), err)
+	}
+	if resp.Success || resp.Error.Code != wantCode {
+		t.Errorf("%v: body %s, want error %v", adapter, w.Body.String(), wantCode)
+	}
+}
+
+func TestPanicRecoveryAfterHeaderWritten(t *testing.T) {
+	handler := PanicRecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"success":true,`))
+		panic("boom")
+	}))
+	w := httptest.NewRecorder()
+	defer func() {
+		if recovered := recover(); recovered != http.ErrAbortHandler {
+			t.Fatalf("recovered %v, want http.ErrAbortHandler", recovered)
+		}
+		if body := w.Body.String(); body != `{"success":true,` {
+			t.Fatalf("body %q, want only what the handler wrote", body)
+		}
+	}()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+}
+
+func TestPanicRecoveryPassesAbortHandler(t *testing.T) {
+	handler := PanicRecoveryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if recovered := recover(); recovered != http.ErrAbortHandler {
+			t.Fatalf("recovered %v, want http.ErrAbortHandler", recovered)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+}
@@ -0,0 +1,374 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/revel/revel"
+)
+
+// RenderRequest is what the rendering logic needs to know about the request,
+// adapters exist for revel.Request and http.Request
+type RenderRequest interface {
+	Method() string
+	Path() string
+	RemoteAddr() string
+	Header(key string) string
+	// Locales returns preferred locales of the client, most preferred first
+	Locales() []string
+	// RequestID returns id assigned by RequestIDFilter or RequestIDMiddleware
+	RequestID() string
+}
+
+// RenderWriter writes rendered response, adapters exist for revel.Response and http.ResponseWriter
+type RenderWriter interface {
+	AddHeader(key, value string)
+	WriteResponse(status int, contentType string, body []byte) error
+}
+
+// RenderedResponse is a fully rendered response, the same request and payload always render the same response
+// regardless of the web framework
+type RenderedResponse struct {
+	Status      int
+	Header      http.Header
+	ContentType string
+	Body        []byte
+	// ETag identifies body of successful GET and HEAD responses, it does not change with request id of the envelope
+	ETag string
+}
+
+// WriteTo writes headers, status and body into w
+func (resp RenderedResponse) WriteTo(w RenderWriter) error {
+	keys := make([]string, 0, len(resp.Header))
+	for key := range resp.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range resp.Header[key] {
+			w.AddHeader(key, value)
+		}
+	}
+	return w.WriteResponse(resp.Status, resp.ContentType, resp.Body)
+}
+
+// RenderSuccess renders data in JSONResponse or the envelope version requested by req, status is 200 when it is zero
+func RenderSuccess(req RenderRequest, status int, data interface{}) RenderedResponse {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	resp := renderNegotiated(req, status, toEnvelope(req, JSONResponse{
+		Success:   true,
+		Data:      data,
+		Error:     nil,
+		RequestID: req.RequestID(),
+	}))
+	if method := req.Method(); method == http.MethodGet || method == http.MethodHead {
+		resp.ETag = resourceETag(resp, req.RequestID())
+	}
+	return resp
+}
+
+// RenderError renders err in JSONResponse or as problem document, see ErrorFormat.
+// Message is localized and internal details are hidden when it is required.
+func RenderError(req RenderRequest, err *APIError) RenderedResponse {
+	if err == nil {
+		panic(fmt.Errorf("err must not be null"))
+	}
+
+	if err.HTTPStatus == 0 {
+		err.HTTPStatus = http.StatusInternalServerError
+	}
+	err = localizeAPIError(req, err)
+	err = hideInternalError(req, err)
+
+	var resp RenderedResponse
+	if errorFormat(req) == ErrorFormatProblem {
+		problem := NewProblemDocument(err, req.Path())
+		problem.RequestID = req.RequestID()
+		resp = renderNegotiated(req, err.HTTPStatus, problem)
+	} else {
+		resp = renderNegotiated(req, err.HTTPStatus, toEnvelope(req, JSONResponse{
+			Success:   false,
+			Data:      nil,
+			Error:     err,
+			RequestID: req.RequestID(),
+		}))
+	}
+	for key, values := range err.Headers {
+		for _, value := range values {
+			resp.Header.Add(key, value)
+		}
+	}
+	return resp
+}
+
+// renderFallback is used when payload cannot be encoded
+func renderFallback(err error) RenderedResponse {
+	log.Println("[Renderer] failed to encode response due to error:", err)
+	body := fmt.Sprintf(`{"success":false,"data":null,"error":{"id":%d,"code":%q,"httpStatus":500,"message":%q}}`,
+		ErrorCodeUnspecified.ID, ErrorCodeUnspecified.Code, ErrorCodeUnspecified.Message)
+	return RenderedResponse{
+		Status:      http.StatusInternalServerError,
+		Header:      http.Header{},
+		ContentType: MediaTypeJSON + "; charset=utf-8",
+		Body:        []byte(body),
+	}
+}
+
+// revelRenderRequest adapts revel.Request to RenderRequest, requestID and clientIP are taken from the controller
+type revelRenderRequest struct {
+	req       *revel.Request
+	requestID string
+	clientIP  string
+}
+
+func newRevelRenderRequest(c *revel.Controller) revelRenderRequest {
+	return revelRenderRequest{req: c.Request, requestID: RequestIDOfController(c), clientIP: c.ClientIP}
+}
+
+func (r revelRenderRequest) RequestID() string {
+	if r.requestID == "" {
+		return r.Header(RequestIDHeader)
+	}
+	return r.requestID
+}
+
+func (r revelRenderRequest) Method() string {
+	if r.req == nil {
+		return ""
+	}
+	return r.req.Method
+}
+
+func (r revelRenderRequest) Path() string {
+	if r.req == nil || r.req.In == nil {
+		return ""
+	}
+	return r.req.GetPath()
+}
+
+// RemoteAddr returns client IP resolved by revel which honours "app.behind.proxy",
+// address of the connection is used when the controller has none
+func (r revelRenderRequest) RemoteAddr() string {
+	if r.clientIP != "" {
+		return r.clientIP
+	}
+	if r.req == nil {
+		return ""
+	}
+	return r.req.RemoteAddr
+}
+
+func (r revelRenderRequest) Header(key string) string {
+	if r.req == nil || r.req.Header == nil {
+		return ""
+	}
+	return r.req.Header.Get(key)
+}
+
+// Locales returns languages of Accept-Language like httpRenderRequest so both stacks render the same response,
+// locale resolved by revel e.g. from cookie is the last fallback
+func (r revelRenderRequest) Locales() []string {
+	if r.req == nil {
+		return []string{}
+	}
+	locales := parseAcceptLanguage(r.Header("Accept-Language"))
+	if r.req.Locale != "" && !containsString(locales, r.req.Locale) {
+		locales = append(locales, r.req.Locale)
+	}
+	return locales
+}
+
+// revelRenderWriter adapts revel.Response to RenderWriter
+type revelRenderWriter struct {
+	resp *revel.Response
+}
+
+func (w revelRenderWriter) AddHeader(key, value string) {
+	w.resp.Out.Header().Add(key, value)
+}
+
+func (w revelRenderWriter) WriteResponse(status int, contentType string, body []byte) error {
+	w.resp.ContentType = contentType
+	w.resp.Status = status
+	w.resp.WriteHeader(status, contentType)
+	if len(body) == 0 {
+		return nil
+	}
+	_, err := w.resp.GetWriter().Write(body)
+	return err
+}
+
+// renderedResult is revel.Result of RenderedResponse
+type renderedResult struct {
+	response  RenderedResponse
+	requestID string
+}
+
+func (res renderedResult) Apply(req *revel.Request, resp *revel.Response) {
+	response := finalizeResponse(revelRenderRequest{req: req, requestID: res.requestID}, res.response)
+	if err := response.WriteTo(revelRenderWriter{resp: resp}); err != nil {
+		log.Println("[RevelResultRenderer] failed to write response due to error:", err)
+	}
+}
+
+// httpRenderRequest adapts http.Request to RenderRequest
+type httpRenderRequest struct {
+	req *http.Request
+}
+
+// NewHTTPRenderRequest adapts http.Request to RenderRequest
+func NewHTTPRenderRequest(req *http.Request) RenderRequest {
+	return httpRenderRequest{req: req}
+}
+
+func (r httpRenderRequest) Method() string {
+	return r.req.Method
+}
+
+func (r httpRenderRequest) Path() string {
+	return r.req.URL.Path
+}
+
+func (r httpRenderRequest) RemoteAddr() string {
+	return r.req.RemoteAddr
+}
+
+func (r httpRenderRequest) Header(key string) string {
+	return r.req.Header.Get(key)
+}
+
+func (r httpRenderRequest) RequestID() string {
+	if requestID := RequestIDFromContext(r.req.Context()); requestID != "" {
+		return requestID
+	}
+	return r.req.Header.Get(RequestIDHeader)
+}
+
+func (r httpRenderRequest) Locales() []string {
+	return parseAcceptLanguage(r.req.Header.Get("Accept-Language"))
+}
+
+// httpRenderWriter adapts http.ResponseWriter to RenderWriter
+type httpRenderWriter struct {
+	w http.ResponseWriter
+}
+
+// NewHTTPRenderWriter adapts http.ResponseWriter to RenderWriter
+func NewHTTPRenderWriter(w http.ResponseWriter) RenderWriter {
+	return httpRenderWriter{w: w}
+}
+
+func (w httpRenderWriter) AddHeader(key, value string) {
+	w.w.Header().Add(key, value)
+}
+
+func (w httpRenderWriter) WriteResponse(status int, contentType string, body []byte) error {
+	w.w.Header().Set("Content-Type", contentType)
+	w.w.WriteHeader(status)
+	if len(body) == 0 {
+		return nil
+	}
+	_, err := w.w.Write(body)
+	return err
+}
+
+// HTTPResultRenderer renders JSONResponse for net/http handlers, the output is identical to RevelResultRenderer
+type HTTPResultRenderer struct {
+	w   http.ResponseWriter
+	req *http.Request
+}
+
+// NewHTTPResultRenderer creates renderer of request req
+func NewHTTPResultRenderer(w http.ResponseWriter, req *http.Request) HTTPResultRenderer {
+	if w == nil || req == nil {
+		panic(fmt.Errorf("response writer and request cannot be null"))
+	}
+	return HTTPResultRenderer{w: w, req: req}
+}
+
+// RenderJSONSuccess renders data with status 200
+func (r *HTTPResultRenderer) RenderJSONSuccess(data interface{}) {
+	r.RenderJSONSuccessWithStatus(http.StatusOK, data)
+}
+
+// RenderJSONSuccessWithStatus renders data with status e.g. 201
+func (r *HTTPResultRenderer) RenderJSONSuccessWithStatus(status int, data interface{}) {
+	r.write(RenderSuccess(httpRenderRequest{req: r.req}, status, data))
+}
+
+// RenderJSONError renders err
+func (r *HTTPResultRenderer) RenderJSONError(err *APIError) {
+	r.write(RenderError(httpRenderRequest{req: r.req}, err))
+}
+
+// RenderJSONErrorWithUnspecificError renders err as ErrorCodeUnspecified
+func (r *HTTPResultRenderer) RenderJSONErrorWithUnspecificError(err error) {
+	if err == nil {
+		panic(fmt.Errorf("err must not be null"))
+	}
+	r.RenderJSONError(NewAPIErrorFromCode(ErrorCodeUnspecified, err))
+}
+
+// RenderValidationErrors renders 400 error carrying fields
+func (r *HTTPResultRenderer) RenderValidationErrors(fields []FieldError) {
+	r.RenderJSONError(NewValidationAPIError(fields))
+}
+
+// BindJSON decodes JSON body of the request into out then validates it with ValidateStruct
+func (r *HTTPResultRenderer) BindJSON(out interface{}, opts BindOptions) *APIError {
+	return DecodeJSONBody(r.req.Header.Get("Content-Type"), r.req.Body, out, opts)
+}
+
+func (r *HTTPResultRenderer) write(resp RenderedResponse) {
+	resp = finalizeResponse(httpRenderRequest{req: r.req}, resp)
+	if err := resp.WriteTo(httpRenderWriter{w: r.w}); err != nil {
+		log.Println("[HTTPResultRenderer] failed to write response due to error:", err)
+	}
+}
+
+// parseAcceptLanguage returns languages of Accept-Language header sorted by quality
+func parseAcceptLanguage(header string) []string {
+	type language struct {
+		tag     string
+		quality float64
+	}
+	languages := []language{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		if fields[0] == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range fields[1:] {
+			if param = strings.TrimSpace(param); strings.HasPrefix(param, "q=") {
+				if f, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = f
+				}
+			}
+		}
+		languages = append(languages, language{tag: fields[0], quality: quality})
+	}
+	sort.SliceStable(languages, func(i, j int) bool { return languages[i].quality > languages[j].quality })
+
+	tags := make([]string, 0, len(languages))
+	for _, l := range languages {
+		tags = append(tags, l.tag)
+	}
+	return tags
+}
+
+// remoteIP returns ip part of remote address
+func remoteIP(remoteAddr string) net.IP {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	return net.ParseIP(host)
+}
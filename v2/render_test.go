@@ -0,0 +1,128 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/revel/revel"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files of testdata")
+
+type renderTestCase struct {
+	name   string
+	accept string
+	revel  func(r *RevelResultRenderer) revel.Result
+	http   func(r *HTTPResultRenderer)
+}
+
+func renderTestCases() []renderTestCase {
+	data := map[string]interface{}{"id": 1, "name": "chanyut", "tags": []string{"go", "<db>"}}
+	apiErr := NewAPIErrorFromCode(ErrorCodeUnspecified, errors.New("boom"))
+	// internal message is hidden by default, a fixed correlation id keeps the output deterministic
+	apiErr.CorrelationID = "golden-correlation-id"
+	fields := []FieldError{
+		NewFieldError("username", "is required", "required", nil),
+		NewFieldError("age", "must be at least 18", "min", 16),
+	}
+
+	cases := []renderTestCase{
+		{name: "success", accept: MediaTypeJSON},
+		{name: "error", accept: MediaTypeJSON},
+		{name: "validation", accept: MediaTypeJSON},
+		{name: "problem", accept: ProblemJSONContentType},
+		{name: "xml", accept: MediaTypeXML},
+		{name: "msgpack", accept: MediaTypeMsgPack},
+	}
+	for i := range cases {
+		switch cases[i].name {
+		case "error", "problem":
+			cases[i].revel = func(r *RevelResultRenderer) revel.Result { return r.RenderJSONError(apiErr) }
+			cases[i].http = func(r *HTTPResultRenderer) { r.RenderJSONError(apiErr) }
+		case "validation":
+			cases[i].revel = func(r *RevelResultRenderer) revel.Result { return r.RenderValidationErrors(fields) }
+			cases[i].http = func(r *HTTPResultRenderer) { r.RenderValidationErrors(fields) }
+		default:
+			cases[i].revel = func(r *RevelResultRenderer) revel.Result { return r.RenderJSONSuccess(data) }
+			cases[i].http = func(r *HTTPResultRenderer) { r.RenderJSONSuccess(data) }
+		}
+	}
+	return cases
+}
+
+func newRenderTestRequest(accept string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set("Accept", accept)
+	req.Header.Set("Accept-Language", "th-TH, en;q=0.8")
+	req.Header.Set(RequestIDHeader, "golden-request-id")
+	return req
+}
+
+func renderWithRevel(c renderTestCase) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	controller := newTestController(newRenderTestRequest(c.accept), w)
+	controller.Request.Locale = "en"
+
+	renderer := NewRevelResultRenderer(controller)
+	c.revel(&renderer).Apply(controller.Request, controller.Response)
+	return w
+}
+
+func renderWithHTTP(c renderTestCase) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	renderer := NewHTTPResultRenderer(w, newRenderTestRequest(c.accept))
+	c.http(&renderer)
+	return w
+}
+
+// dumpResponse returns status, sorted headers and body of w
+func dumpResponse(w *httptest.ResponseRecorder) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d\n", w.Code)
+	keys := make([]string, 0, len(w.Header()))
+	for key := range w.Header() {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "%s: %s\n", key, strings.Join(w.Header()[key], ", "))
+	}
+	buf.WriteString("\n")
+	buf.Write(w.Body.Bytes())
+	return buf.Bytes()
+}
+
+func TestRenderAdaptersGolden(t *testing.T) {
+	for _, c := range renderTestCases() {
+		t.Run(c.name, func(t *testing.T) {
+			revelOut := dumpResponse(renderWithRevel(c))
+			httpOut := dumpResponse(renderWithHTTP(c))
+			if !bytes.Equal(revelOut, httpOut) {
+				t.Fatalf("adapters differ\nrevel:\n%s\nnet/http:\n%s", revelOut, httpOut)
+			}
+
+			golden := filepath.Join("testdata", "render", c.name+".golden")
+			if *updateGolden {
+				if err := ioutil.WriteFile(golden, httpOut, 0644); err != nil {
+					t.Fatalf("failed to update %v: %v", golden, err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read %v: %v", golden, err)
+			}
+			if !bytes.Equal(httpOut, want) {
+				t.Fatalf("response differs from %v\ngot:\n%s\nwant:\n%s", golden, httpOut, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/revel/revel"
+)
+
+// RequestIDHeader is header request id is accepted from and returned in
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDArgKey is key of request id in revel.Controller.Args
+const requestIDArgKey = "requestId"
+
+// maxRequestIDLength limits request ids accepted from clients
+const maxRequestIDLength = 128
+
+type requestIDContextKey struct{}
+
+// ContextWithRequestID returns copy of ctx carrying request id
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, requestID)
+}
+
+// RequestIDFromContext returns request id stored by RequestIDMiddleware or ContextWithRequestID
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestID, _ := ctx.Value(requestIDContextKey{}).(string)
+	return requestID
+}
+
+// RequestIDOfController returns request id stored by RequestIDFilter
+func RequestIDOfController(c *revel.Controller) string {
+	if c == nil || c.Args == nil {
+		return ""
+	}
+	requestID, _ := c.Args[requestIDArgKey].(string)
+	return requestID
+}
+
+// RequestIDFilter accepts X-Request-ID of the request or generates one, then keeps it in controller Args
+// and returns it in the response header. It should be placed right after revel.PanicFilter.
+func RequestIDFilter(c *revel.Controller, fc []revel.Filter) {
+	requestID := acceptRequestID(c.Request.Header.Get(RequestIDHeader))
+	c.Args[requestIDArgKey] = requestID
+	c.Response.Out.Header().Set(RequestIDHeader, requestID)
+	fc[0](c, fc[1:])
+}
+
+// RequestIDMiddleware is RequestIDFilter for net/http, the request id is stored in the request context
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requestID := acceptRequestID(req.Header.Get(RequestIDHeader))
+		w.Header().Set(RequestIDHeader, requestID)
+		next.ServeHTTP(w, req.WithContext(ContextWithRequestID(req.Context(), requestID)))
+	})
+}
+
+// acceptRequestID returns requestID when it is a reasonable id made of [A-Za-z0-9._-], otherwise a new one
+func acceptRequestID(requestID string) string {
+	if requestID != "" && len(requestID) <= maxRequestIDLength && isRequestIDSafe(requestID) {
+		return requestID
+	}
+	requestID, err := NewUUID()
+	if err != nil {
+		log.Println("[RequestID] failed to generate request id due to error:", err)
+	}
+	return requestID
+}
+
+func isRequestIDSafe(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func isPrintableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x21 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// logf is the package logger, messages are prefixed with request id when it is known.
+// The request id is passed as an argument since it may come from a client.
+func logf(requestID string, format string, args ...interface{}) {
+	if requestID != "" {
+		log.Printf("[requestId=%s] "+format, append([]interface{}{requestID}, args...)...)
+		return
+	}
+	log.Printf(format, args...)
+}
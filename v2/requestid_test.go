@@ -0,0 +1,59 @@
+package core
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAcceptRequestID(t *testing.T) {
+	cases := []struct {
+		requestID string
+		accepted  bool
+	}{
+		{"abc-123_x.y", true},
+		{"", false},
+		{"abc%s%d", false},
+		{"abc def", false},
+		{"abc\nfake log line", false},
+		{strings.Repeat("a", maxRequestIDLength), true},
+		{strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+	for _, c := range cases {
+		got := acceptRequestID(c.requestID)
+		if c.accepted && got != c.requestID {
+			t.Errorf("acceptRequestID(%q) = %q, want it accepted", c.requestID, got)
+		}
+		if !c.accepted && (got == c.requestID || !isRequestIDSafe(got)) {
+			t.Errorf("acceptRequestID(%q) = %q, want a generated id", c.requestID, got)
+		}
+	}
+}
+
+func TestLogfWithHostileRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	logf("abc%s%d", "[Renderer] status=%v id=%v", 500, 1)
+	if want := "[requestId=abc%s%d] [Renderer] status=500 id=1\n"; buf.String() != want {
+		t.Fatalf("log %q, want %q", buf.String(), want)
+	}
+
+	// a request without RequestIDFilter logs the raw header, which must not break the line either
+	buf.Reset()
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.Header.Set(RequestIDHeader, "abc%s%d")
+	hideInternalError(httpRenderRequest{req: req}, NewAPIError(1, http.StatusInternalServerError, "failed", nil))
+	if buf.Len() == 0 || strings.Contains(buf.String(), "%!") {
+		t.Fatalf("log %q is garbled by the request id", buf.String())
+	}
+}
@@ -2,7 +2,6 @@ package core
 
 import (
 	"fmt"
-	"net/http"
 	"strings"
 
 	"mime/multipart"
@@ -15,15 +14,61 @@ import (
 type RevelRequestMethodType string
 
 const (
-	RevelRequestMethodTypeGET  RevelRequestMethodType = "GET"
-	RevelRequestMethodTypePOST                        = "POST"
-	RevelRequestMethodTypePUT                         = "PUT"
+	RevelRequestMethodTypeGET     RevelRequestMethodType = "GET"
+	RevelRequestMethodTypePOST    RevelRequestMethodType = "POST"
+	RevelRequestMethodTypePUT     RevelRequestMethodType = "PUT"
+	RevelRequestMethodTypePATCH   RevelRequestMethodType = "PATCH"
+	RevelRequestMethodTypeDELETE  RevelRequestMethodType = "DELETE"
+	RevelRequestMethodTypeHEAD    RevelRequestMethodType = "HEAD"
+	RevelRequestMethodTypeOPTIONS RevelRequestMethodType = "OPTIONS"
+	RevelRequestMethodTypeCONNECT RevelRequestMethodType = "CONNECT"
+	RevelRequestMethodTypeTRACE   RevelRequestMethodType = "TRACE"
 )
 
+// MethodOverrideHeader lets clients which can only send POST tunnel another method e.g. DELETE,
+// see ParseRevelRequestMethodTypeWithOverride
+const MethodOverrideHeader = "X-HTTP-Method-Override"
+
+var revelRequestMethodTypes = map[string]RevelRequestMethodType{
+	"GET":     RevelRequestMethodTypeGET,
+	"POST":    RevelRequestMethodTypePOST,
+	"PUT":     RevelRequestMethodTypePUT,
+	"PATCH":   RevelRequestMethodTypePATCH,
+	"DELETE":  RevelRequestMethodTypeDELETE,
+	"HEAD":    RevelRequestMethodTypeHEAD,
+	"OPTIONS": RevelRequestMethodTypeOPTIONS,
+	"CONNECT": RevelRequestMethodTypeCONNECT,
+	"TRACE":   RevelRequestMethodTypeTRACE,
+}
+
+// IsSafe reports whether the method does not modify resources (RFC 7231 section 4.2.1)
+func (m RevelRequestMethodType) IsSafe() bool {
+	switch m {
+	case RevelRequestMethodTypeGET, RevelRequestMethodTypeHEAD, RevelRequestMethodTypeOPTIONS, RevelRequestMethodTypeTRACE:
+		return true
+	}
+	return false
+}
+
+// IsIdempotent reports whether sending the same request many times has the same effect as once (RFC 7231 section 4.2.2)
+func (m RevelRequestMethodType) IsIdempotent() bool {
+	return m.IsSafe() || m == RevelRequestMethodTypePUT || m == RevelRequestMethodTypeDELETE
+}
+
+// ParseRequestMethodType parses method case-insensitively
+func ParseRequestMethodType(method string) (RevelRequestMethodType, error) {
+	methodType, ok := revelRequestMethodTypes[strings.ToUpper(strings.TrimSpace(method))]
+	if !ok {
+		return "", fmt.Errorf("unknown request's method: %v", method)
+	}
+	return methodType, nil
+}
+
 type JSONResponse struct {
-	Success bool        `json:"success"`
-	Data    interface{} `json:"data"`
-	Error   *APIError   `json:"error"`
+	Success   bool        `json:"success"`
+	Data      interface{} `json:"data"`
+	Error     *APIError   `json:"error"`
+	RequestID string      `json:"requestId,omitempty"`
 }
 
 func (resp JSONResponse) InternalErrorString() string {
@@ -47,30 +92,16 @@ func NewRevelResultRenderer(controller *revel.Controller) RevelResultRenderer {
 	return renderer
 }
 
-// RenderJSONSuccess is wrapp er function for rendering json in type of JSONResponse
+// RenderJSONSuccess is wrapper function for rendering JSONResponse,
+// it is rendered as JSON, XML or MessagePack depends on Accept header
 func (r *RevelResultRenderer) RenderJSONSuccess(data interface{}) revel.Result {
-	return r.controller.RenderJSON(JSONResponse{
-		Success: true,
-		Data:    data,
-		Error:   nil,
-	})
+	return r.result(RenderSuccess(r.renderRequest(), r.controller.Response.Status, data))
 }
 
-// RenderJSONError is wrapper function for rendering json in type of JSONResponse
+// RenderJSONError is wrapper function for rendering JSONResponse,
+// it is rendered as JSON, XML or MessagePack depends on Accept header
 func (r *RevelResultRenderer) RenderJSONError(err *APIError) revel.Result {
-	if err == nil {
-		panic(fmt.Errorf("err must not be null"))
-	}
-
-	if err.HTTPStatus == 0 {
-		err.HTTPStatus = http.StatusInternalServerError
-	}
-	r.controller.Response.Status = err.HTTPStatus
-	return r.controller.RenderJSON(JSONResponse{
-		Success: false,
-		Data:    nil,
-		Error:   err,
-	})
+	return r.result(RenderError(r.renderRequest(), err))
 }
 
 // RenderJSONError is wrapper function for rendering json in type of JSONResponse
@@ -79,29 +110,22 @@ func (r *RevelResultRenderer) RenderJSONErrorWithUnspecificError(err error) reve
 		panic(fmt.Errorf("err must not be null"))
 	}
 
-	apiError := NewAPI500Error(0, err.Error(), nil)
-	r.controller.Response.Status = apiError.HTTPStatus
-	return r.controller.RenderJSON(JSONResponse{
-		Success: false,
-		Data:    nil,
-		Error:   apiError,
-	})
+	return r.RenderJSONError(NewAPIErrorFromCode(ErrorCodeUnspecified, err))
 }
 
 // RenderCurrentValidationError is wrapper function for rendering json in type of JSONResponse
 func (r *RevelResultRenderer) RenderCurrentValidationError() revel.Result {
-	errMessages := ""
-	for _, err := range r.controller.Validation.Errors {
-		errMessages += fmt.Sprintf("%s: %s\n", err.Key, err.Message)
-	}
+	return r.RenderValidationErrors(FieldErrorsFromRevelValidation(r.controller.Validation.Errors, r.controller.Params))
+}
+
+func (r *RevelResultRenderer) renderRequest() RenderRequest {
+	return newRevelRenderRequest(r.controller)
+}
 
-	validationError := NewAPI400Error(0, errMessages, nil)
-	r.controller.Response.Status = http.StatusBadRequest
-	return r.controller.RenderJSON(JSONResponse{
-		Success: false,
-		Data:    nil,
-		Error:   validationError,
-	})
+// result keeps status on the controller response, so filters can read it before the result is applied
+func (r *RevelResultRenderer) result(response RenderedResponse) revel.Result {
+	r.controller.Response.Status = response.Status
+	return renderedResult{response: response, requestID: RequestIDOfController(r.controller)}
 }
 
 // GetFileDataFromFileHeader read data from given fileHeader (multipart.FileHeader) and return as []byte
@@ -119,13 +143,41 @@ func GetFileDataFromFileHeader(fileHeader *multipart.FileHeader) ([]byte, error)
 	return data, nil
 }
 
+// ParseRevelRequestMethodType returns method which req is sent with
+func ParseRevelRequestMethodType(req *revel.Request) (RevelRequestMethodType, error) {
+	return ParseRequestMethodType(req.Method)
+}
+
+// ParseRevelRequestMethodTypeWithOverride is like ParseRevelRequestMethodType but POST requests may override
+// the method with X-HTTP-Method-Override header when "api.method_override.enabled" is true (default false),
+// overriding into POST, CONNECT or TRACE is not allowed
+func ParseRevelRequestMethodTypeWithOverride(req *revel.Request) (RevelRequestMethodType, error) {
+	methodType, err := ParseRevelRequestMethodType(req)
+	if err != nil || methodType != RevelRequestMethodTypePOST || req.Header == nil ||
+		!configBool("api.method_override.enabled", false) {
+		return methodType, err
+	}
+
+	override := req.Header.Get(MethodOverrideHeader)
+	if override == "" {
+		return methodType, nil
+	}
+	overrideType, err := ParseRequestMethodType(override)
+	if err != nil {
+		return "", err
+	}
+	switch overrideType {
+	case RevelRequestMethodTypePOST, RevelRequestMethodTypeCONNECT, RevelRequestMethodTypeTRACE:
+		return "", fmt.Errorf("request's method cannot be overridden to %v", overrideType)
+	}
+	return overrideType, nil
+}
+
+// GetMethodTypeOfRevelRequest is like ParseRevelRequestMethodType but it panics on unknown method
 func GetMethodTypeOfRevelRequest(req *revel.Request) RevelRequestMethodType {
-	if strings.ToLower(req.Method) == "get" {
-		return RevelRequestMethodTypeGET
-	} else if strings.ToLower(req.Method) == "post" {
-		return RevelRequestMethodTypePOST
-	} else if strings.ToLower(req.Method) == "put" {
-		return RevelRequestMethodTypePUT
+	methodType, err := ParseRevelRequestMethodType(req)
+	if err != nil {
+		panic(err)
 	}
-	panic(fmt.Errorf("unknow request's method: %v", req.Method))
+	return methodType
 }
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/revel/config"
+	"github.com/revel/revel"
+)
+
+func newRevelTestRequest(method string, override string) *revel.Request {
+	r := httptest.NewRequest(method, "/users/1", nil)
+	if override != "" {
+		r.Header.Set(MethodOverrideHeader, override)
+	}
+	return newTestController(r, nil).Request
+}
+
+func TestParseRevelRequestMethodType(t *testing.T) {
+	req := newRevelTestRequest(http.MethodPost, "DELETE")
+	if got := GetMethodTypeOfRevelRequest(req); got != RevelRequestMethodTypePOST {
+		t.Fatalf("GetMethodTypeOfRevelRequest() = %v, want POST", got)
+	}
+	if got, _ := ParseRevelRequestMethodTypeWithOverride(req); got != RevelRequestMethodTypePOST {
+		t.Fatalf("override is expected to be disabled by default, got %v", got)
+	}
+}
+
+func TestParseRevelRequestMethodTypeWithOverride(t *testing.T) {
+	revel.Config = config.NewContext()
+	revel.Config.SetOption("api.method_override.enabled", "true")
+	defer func() { revel.Config = nil }()
+
+	cases := []struct {
+		method   string
+		override string
+		want     RevelRequestMethodType
+		wantErr  bool
+	}{
+		{http.MethodPost, "", RevelRequestMethodTypePOST, false},
+		{http.MethodPost, "delete", RevelRequestMethodTypeDELETE, false},
+		{http.MethodPost, "PATCH", RevelRequestMethodTypePATCH, false},
+		{http.MethodGet, "DELETE", RevelRequestMethodTypeGET, false},
+		{http.MethodPost, "TRACE", "", true},
+		{http.MethodPost, "FOO", "", true},
+	}
+	for _, c := range cases {
+		got, err := ParseRevelRequestMethodTypeWithOverride(newRevelTestRequest(c.method, c.override))
+		if got != c.want || (err != nil) != c.wantErr {
+			t.Errorf("%v overridden to %q: got %v, %v, want %v, error %v", c.method, c.override, got, err, c.want, c.wantErr)
+		}
+	}
+	if got := GetMethodTypeOfRevelRequest(newRevelTestRequest(http.MethodPost, "DELETE")); got != RevelRequestMethodTypePOST {
+		t.Errorf("GetMethodTypeOfRevelRequest() = %v, want the real method", got)
+	}
+}
@@ -0,0 +1,374 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TransactionCollectionName is the collection which two-phase commit transactions are stored
+const TransactionCollectionName = "transactions"
+
+// pendingTransactionsField is added to every document touched by a transaction until it is done
+const pendingTransactionsField = "pendingTransactions"
+
+// TransactionState is state of two-phase commit transaction
+type TransactionState string
+
+const (
+	TransactionStateInitial   TransactionState = "initial"
+	TransactionStatePending   TransactionState = "pending"
+	TransactionStateApplied   TransactionState = "applied"
+	TransactionStateDone      TransactionState = "done"
+	TransactionStateCanceling TransactionState = "canceling"
+	TransactionStateCanceled  TransactionState = "canceled"
+)
+
+// transactionTransitions lists states which a transaction may move to from each state
+var transactionTransitions = map[TransactionState][]TransactionState{
+	TransactionStateInitial:   {TransactionStatePending, TransactionStateCanceled},
+	TransactionStatePending:   {TransactionStateApplied, TransactionStateCanceling},
+	TransactionStateApplied:   {TransactionStateDone},
+	TransactionStateCanceling: {TransactionStateCanceled},
+}
+
+// ErrTransactionConflict is returned when a transaction operation cannot be applied,
+// either the document is missing or its condition does not match anymore.
+// RunInTransaction retries when it gets this error.
+var ErrTransactionConflict = errors.New("transaction conflict")
+
+var (
+	// TransactionMaxRetries is number of retries RunInTransaction performs on conflict
+	TransactionMaxRetries = 3
+	// TransactionRetryInterval is base waiting time between retries, it grows on every retry
+	TransactionRetryInterval = 50 * time.Millisecond
+)
+
+// TransactionOperation is a single document update in a transaction.
+// Rollback must revert what Update did, it is applied only if Update was applied.
+type TransactionOperation struct {
+	Collection string
+	DocumentID interface{}
+	Condition  bson.M
+	Update     bson.M
+	Rollback   bson.M
+}
+
+// Transaction is a document stored in transactions collection
+type Transaction struct {
+	ID           bson.ObjectId          `bson:"_id"`
+	State        TransactionState       `bson:"state"`
+	Operations   []transactionOperation `bson:"operations"`
+	LastModified time.Time              `bson:"lastModified"`
+}
+
+// transactionOperation is stored form of TransactionOperation, update documents contain
+// $ operators which cannot be stored as field names so they are kept as raw bson.
+type transactionOperation struct {
+	Collection string      `bson:"collection"`
+	DocumentID interface{} `bson:"documentId"`
+	Condition  []byte      `bson:"condition,omitempty"`
+	Update     []byte      `bson:"update"`
+	Rollback   []byte      `bson:"rollback"`
+}
+
+// Tx collects operations to be committed by RunInTransaction
+type Tx struct {
+	operations []TransactionOperation
+}
+
+// Update adds update of document to transaction, rollback is applied when transaction is canceled
+func (tx *Tx) Update(collection string, id interface{}, update bson.M, rollback bson.M) {
+	tx.UpdateIf(collection, id, nil, update, rollback)
+}
+
+// UpdateIf likes Update but the update is applied only when document matches condition,
+// otherwise the transaction is rolled back and retried e.g. condition: bson.M{"credit": bson.M{"$gte": 100}}
+func (tx *Tx) UpdateIf(collection string, id interface{}, condition bson.M, update bson.M, rollback bson.M) {
+	tx.operations = append(tx.operations, TransactionOperation{
+		Collection: collection,
+		DocumentID: id,
+		Condition:  condition,
+		Update:     update,
+		Rollback:   rollback,
+	})
+}
+
+// RunInTransaction runs fn to collect operations then commits them with two-phase commit.
+// When fn or commit returns ErrTransactionConflict, the whole process is retried up to TransactionMaxRetries times.
+func (mgoDb *MgoDb) RunInTransaction(fn func(tx *Tx) error) error {
+	var err error
+	for attempt := 0; attempt <= TransactionMaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(TransactionRetryInterval * time.Duration(attempt))
+		}
+
+		tx := &Tx{}
+		err = fn(tx)
+		if err == nil {
+			err = mgoDb.commitTransaction(tx.operations)
+		}
+		if !errors.Is(err, ErrTransactionConflict) {
+			return err
+		}
+		mgoDb.logf("[MgoDb::RunInTransaction] conflict on attempt %v", attempt+1)
+	}
+	return err
+}
+
+// RecoverTransactions finishes transactions which are not modified longer than timeout.
+// Pending transactions are rolled forward (or back if they conflict), applied ones are completed
+// and canceling ones are rolled back. It returns number of recovered transactions.
+func (mgoDb *MgoDb) RecoverTransactions(timeout time.Duration) (int, error) {
+	query := bson.M{
+		"state": bson.M{"$in": []TransactionState{
+			TransactionStateInitial,
+			TransactionStatePending,
+			TransactionStateApplied,
+			TransactionStateCanceling,
+		}},
+		"lastModified": bson.M{"$lt": time.Now().Add(-timeout)},
+	}
+	transactions := []Transaction{}
+	if err := mgoDb.Db.C(TransactionCollectionName).Find(query).All(&transactions); err != nil {
+		return 0, err
+	}
+
+	recovered := 0
+	for _, t := range transactions {
+		var err error
+		switch t.State {
+		case TransactionStateInitial:
+			err = mgoDb.setTransactionState(t.ID, TransactionStateInitial, TransactionStateCanceled)
+		case TransactionStatePending:
+			err = mgoDb.applyTransaction(t)
+			if errors.Is(err, ErrTransactionConflict) {
+				err = nil
+			}
+		case TransactionStateApplied:
+			err = mgoDb.finishTransaction(t)
+		case TransactionStateCanceling:
+			err = mgoDb.rollbackTransaction(t)
+		}
+		if err != nil {
+			return recovered, fmt.Errorf("[MgoDb::RecoverTransactions] failed to recover transaction %v due to error: %v", t.ID.Hex(), err)
+		}
+		recovered++
+	}
+	return recovered, nil
+}
+
+// RunTransactionRecoveryJob calls RecoverTransactions every interval until ctx is done
+func (mgoDb *MgoDb) RunTransactionRecoveryJob(ctx context.Context, interval time.Duration, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := mgoDb.RecoverTransactions(timeout)
+			if err != nil {
+				mgoDb.logf("[MgoDb::RunTransactionRecoveryJob] %v", err)
+			} else if n > 0 {
+				mgoDb.logf("[MgoDb::RunTransactionRecoveryJob] recovered %v transactions", n)
+			}
+		}
+	}
+}
+
+func (mgoDb *MgoDb) commitTransaction(operations []TransactionOperation) error {
+	if len(operations) == 0 {
+		return nil
+	}
+
+	t := Transaction{
+		ID:           bson.NewObjectId(),
+		State:        TransactionStateInitial,
+		LastModified: time.Now(),
+	}
+	for _, op := range operations {
+		stored, err := newStoredTransactionOperation(op)
+		if err != nil {
+			return err
+		}
+		t.Operations = append(t.Operations, stored)
+	}
+	if err := mgoDb.Db.C(TransactionCollectionName).Insert(t); err != nil {
+		return err
+	}
+	if err := mgoDb.setTransactionState(t.ID, TransactionStateInitial, TransactionStatePending); err != nil {
+		return err
+	}
+	return mgoDb.applyTransaction(t)
+}
+
+// applyTransaction applies operations of pending transaction, it is safe to be called more than once
+func (mgoDb *MgoDb) applyTransaction(t Transaction) error {
+	for _, op := range t.Operations {
+		condition, update, _, err := op.decode()
+		if err != nil {
+			return err
+		}
+
+		query := transactionOperationQuery(op.DocumentID, t.ID, condition)
+		err = mgoDb.updateTransactionDocument(op.Collection, query, withTransactionMarker(update, "$push", t.ID))
+		if err == mgo.ErrNotFound {
+			// operation is already applied by previous attempt
+			n, countErr := mgoDb.Db.C(op.Collection).Find(bson.M{"_id": op.DocumentID, pendingTransactionsField: t.ID}).Count()
+			if countErr != nil {
+				return countErr
+			}
+			if n > 0 {
+				continue
+			}
+			if err := mgoDb.cancelTransaction(t); err != nil {
+				return err
+			}
+			return ErrTransactionConflict
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := mgoDb.setTransactionState(t.ID, TransactionStatePending, TransactionStateApplied); err != nil {
+		return err
+	}
+	return mgoDb.finishTransaction(t)
+}
+
+// finishTransaction removes transaction marker from documents of applied transaction
+func (mgoDb *MgoDb) finishTransaction(t Transaction) error {
+	for _, op := range t.Operations {
+		err := mgoDb.updateTransactionDocument(
+			op.Collection,
+			bson.M{"_id": op.DocumentID, pendingTransactionsField: t.ID},
+			bson.M{"$pull": bson.M{pendingTransactionsField: t.ID}},
+		)
+		if err != nil && err != mgo.ErrNotFound {
+			return err
+		}
+	}
+	return mgoDb.setTransactionState(t.ID, TransactionStateApplied, TransactionStateDone)
+}
+
+func (mgoDb *MgoDb) cancelTransaction(t Transaction) error {
+	if err := mgoDb.setTransactionState(t.ID, TransactionStatePending, TransactionStateCanceling); err != nil {
+		return err
+	}
+	return mgoDb.rollbackTransaction(t)
+}
+
+// rollbackTransaction reverts only operations which have been applied, marked by transaction id
+func (mgoDb *MgoDb) rollbackTransaction(t Transaction) error {
+	for _, op := range t.Operations {
+		_, _, rollback, err := op.decode()
+		if err != nil {
+			return err
+		}
+		err = mgoDb.updateTransactionDocument(
+			op.Collection,
+			bson.M{"_id": op.DocumentID, pendingTransactionsField: t.ID},
+			withTransactionMarker(rollback, "$pull", t.ID),
+		)
+		if err != nil && err != mgo.ErrNotFound {
+			return err
+		}
+	}
+	return mgoDb.setTransactionState(t.ID, TransactionStateCanceling, TransactionStateCanceled)
+}
+
+// updateTransactionDocument updates document touched by transaction, it is recorded into audit log when audit is enabled
+func (mgoDb *MgoDb) updateTransactionDocument(collection string, query bson.M, update bson.M) error {
+	if mgoDb.audit == nil {
+		return mgoDb.Db.C(collection).Update(query, update)
+	}
+	return mgoDb.updateWithAudit(mgoDb.Db.C(collection), query, update)
+}
+
+func (mgoDb *MgoDb) setTransactionState(id bson.ObjectId, from TransactionState, to TransactionState) error {
+	if !isTransactionTransitionAllowed(from, to) {
+		return fmt.Errorf("[MgoDb::Transaction] invalid transition of transaction %v from %v to %v", id.Hex(), from, to)
+	}
+	return mgoDb.Db.C(TransactionCollectionName).Update(
+		bson.M{"_id": id, "state": from},
+		bson.M{"$set": bson.M{"state": to, "lastModified": time.Now()}},
+	)
+}
+
+func newStoredTransactionOperation(op TransactionOperation) (transactionOperation, error) {
+	stored := transactionOperation{
+		Collection: op.Collection,
+		DocumentID: op.DocumentID,
+	}
+	var err error
+	if op.Condition != nil {
+		if stored.Condition, err = bson.Marshal(op.Condition); err != nil {
+			return stored, err
+		}
+	}
+	if stored.Update, err = bson.Marshal(op.Update); err != nil {
+		return stored, err
+	}
+	if op.Rollback == nil {
+		op.Rollback = bson.M{}
+	}
+	stored.Rollback, err = bson.Marshal(op.Rollback)
+	return stored, err
+}
+
+func (op transactionOperation) decode() (condition bson.M, update bson.M, rollback bson.M, err error) {
+	condition, update, rollback = bson.M{}, bson.M{}, bson.M{}
+	if len(op.Condition) > 0 {
+		if err = bson.Unmarshal(op.Condition, &condition); err != nil {
+			return
+		}
+	}
+	if err = bson.Unmarshal(op.Update, &update); err != nil {
+		return
+	}
+	err = bson.Unmarshal(op.Rollback, &rollback)
+	return
+}
+
+// isTransactionTransitionAllowed returns true when transaction in state from may move to state to
+func isTransactionTransitionAllowed(from TransactionState, to TransactionState) bool {
+	for _, state := range transactionTransitions[from] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transactionOperationQuery matches document of operation which is not applied by transaction id yet,
+// condition is put in $and so its keys cannot replace _id or pendingTransactions
+func transactionOperationQuery(documentID interface{}, id bson.ObjectId, condition bson.M) bson.M {
+	query := bson.M{"_id": documentID, pendingTransactionsField: bson.M{"$ne": id}}
+	if len(condition) > 0 {
+		query["$and"] = []bson.M{condition}
+	}
+	return query
+}
+
+// withTransactionMarker returns copy of update with {operator: {pendingTransactions: id}} merged in
+func withTransactionMarker(update bson.M, operator string, id bson.ObjectId) bson.M {
+	result := bson.M{}
+	for key, value := range update {
+		result[key] = value
+	}
+	fields := bson.M{}
+	if existing, ok := result[operator].(bson.M); ok {
+		for key, value := range existing {
+			fields[key] = value
+		}
+	}
+	fields[pendingTransactionsField] = id
+	result[operator] = fields
+	return result
+}
@@ -0,0 +1,167 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestWithTransactionMarker(t *testing.T) {
+	id := bson.NewObjectId()
+	cases := []struct {
+		name     string
+		update   bson.M
+		operator string
+		want     bson.M
+	}{
+		{
+			name:     "empty update",
+			update:   bson.M{},
+			operator: "$push",
+			want:     bson.M{"$push": bson.M{pendingTransactionsField: id}},
+		},
+		{
+			name:     "other operator is kept",
+			update:   bson.M{"$inc": bson.M{"credit": -100}},
+			operator: "$push",
+			want:     bson.M{"$inc": bson.M{"credit": -100}, "$push": bson.M{pendingTransactionsField: id}},
+		},
+		{
+			name:     "same operator is merged",
+			update:   bson.M{"$pull": bson.M{"tags": "a"}},
+			operator: "$pull",
+			want:     bson.M{"$pull": bson.M{"tags": "a", pendingTransactionsField: id}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			original := bson.M{}
+			for key, value := range c.update {
+				original[key] = value
+			}
+			got := withTransactionMarker(c.update, c.operator, id)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("withTransactionMarker() = %#v, want %#v", got, c.want)
+			}
+			if !reflect.DeepEqual(c.update, original) {
+				t.Fatalf("update is modified to %#v", c.update)
+			}
+		})
+	}
+}
+
+func TestTransactionOperationDecode(t *testing.T) {
+	cases := []struct {
+		name         string
+		op           TransactionOperation
+		wantRollback bson.M
+	}{
+		{
+			name: "with condition",
+			op: TransactionOperation{
+				Collection: "accounts",
+				DocumentID: "a",
+				Condition:  bson.M{"credit": bson.M{"$gte": 100}},
+				Update:     bson.M{"$inc": bson.M{"credit": -100}},
+				Rollback:   bson.M{"$inc": bson.M{"credit": 100}},
+			},
+			wantRollback: bson.M{"$inc": bson.M{"credit": 100}},
+		},
+		{
+			name: "without condition and rollback",
+			op: TransactionOperation{
+				Collection: "accounts",
+				DocumentID: "b",
+				Update:     bson.M{"$set": bson.M{"name": "chanyut"}},
+			},
+			wantRollback: bson.M{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			stored, err := newStoredTransactionOperation(c.op)
+			if err != nil {
+				t.Fatalf("newStoredTransactionOperation() error: %v", err)
+			}
+			condition, update, rollback, err := stored.decode()
+			if err != nil {
+				t.Fatalf("decode() error: %v", err)
+			}
+			wantCondition := c.op.Condition
+			if wantCondition == nil {
+				wantCondition = bson.M{}
+			}
+			if !reflect.DeepEqual(condition, wantCondition) {
+				t.Errorf("condition %#v, want %#v", condition, wantCondition)
+			}
+			if !reflect.DeepEqual(update, c.op.Update) {
+				t.Errorf("update %#v, want %#v", update, c.op.Update)
+			}
+			if !reflect.DeepEqual(rollback, c.wantRollback) {
+				t.Errorf("rollback %#v, want %#v", rollback, c.wantRollback)
+			}
+		})
+	}
+}
+
+func TestTransactionOperationQuery(t *testing.T) {
+	id := bson.NewObjectId()
+	cases := []struct {
+		name      string
+		condition bson.M
+		want      bson.M
+	}{
+		{
+			name: "without condition",
+			want: bson.M{"_id": "a", pendingTransactionsField: bson.M{"$ne": id}},
+		},
+		{
+			name:      "reserved keys of condition do not replace the query",
+			condition: bson.M{"_id": "b", pendingTransactionsField: nil, "credit": bson.M{"$gte": 100}},
+			want: bson.M{
+				"_id":                    "a",
+				pendingTransactionsField: bson.M{"$ne": id},
+				"$and":                   []bson.M{{"_id": "b", pendingTransactionsField: nil, "credit": bson.M{"$gte": 100}}},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := transactionOperationQuery("a", id, c.condition); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("transactionOperationQuery() = %#v, want %#v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestTransactionTransitions(t *testing.T) {
+	cases := []struct {
+		from TransactionState
+		to   TransactionState
+		want bool
+	}{
+		{TransactionStateInitial, TransactionStatePending, true},
+		{TransactionStateInitial, TransactionStateCanceled, true},
+		{TransactionStatePending, TransactionStateApplied, true},
+		{TransactionStatePending, TransactionStateCanceling, true},
+		{TransactionStateApplied, TransactionStateDone, true},
+		{TransactionStateCanceling, TransactionStateCanceled, true},
+		{TransactionStateInitial, TransactionStateApplied, false},
+		{TransactionStatePending, TransactionStateDone, false},
+		{TransactionStateApplied, TransactionStateCanceling, false},
+		{TransactionStateDone, TransactionStatePending, false},
+		{TransactionStateCanceled, TransactionStatePending, false},
+	}
+
+	for _, c := range cases {
+		t.Run(string(c.from)+"->"+string(c.to), func(t *testing.T) {
+			if got := isTransactionTransitionAllowed(c.from, c.to); got != c.want {
+				t.Fatalf("isTransactionTransitionAllowed(%v, %v) = %v, want %v", c.from, c.to, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,458 @@
+package core
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ValidationField is passed to validation rules, Parent is the struct holding the field for cross-field rules
+type ValidationField struct {
+	Value  reflect.Value
+	Param  string
+	Parent reflect.Value
+}
+
+// ValidationRuleFunc returns true when the field is valid
+type ValidationRuleFunc func(field ValidationField) bool
+
+type validationRule struct {
+	fn         ValidationRuleFunc
+	message    string
+	crossField bool
+	// checkParam validates param of the rule when a struct type is compiled
+	checkParam func(param string) error
+}
+
+// compiledRule is a rule of a `validate` tag with its param, name is also "required", "omitempty" or "dive"
+type compiledRule struct {
+	name  string
+	param string
+	rule  validationRule
+}
+
+type compiledField struct {
+	index int
+	name  string
+	rules []compiledRule
+}
+
+// compiledStruct is cached per struct type, err is set when a tag of the type is invalid
+type compiledStruct struct {
+	fields []compiledField
+	err    error
+}
+
+var (
+	validationRulesLock sync.RWMutex
+	validationRules     = map[string]validationRule{}
+	validationRegexps   sync.Map
+	compiledStructs     sync.Map
+	emailRegexp         = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	timeType            = reflect.TypeOf(time.Time{})
+)
+
+func init() {
+	registerValidationRule("min", "must be at least %s", checkNumberParam, func(f ValidationField) bool {
+		return compareSize(f, func(size, param float64) bool { return size >= param })
+	})
+	registerValidationRule("max", "must be at most %s", checkNumberParam, func(f ValidationField) bool {
+		return compareSize(f, func(size, param float64) bool { return size <= param })
+	})
+	registerValidationRule("len", "must have length %s", checkNumberParam, func(f ValidationField) bool {
+		return compareSize(f, func(size, param float64) bool { return size == param })
+	})
+	registerValidationRule("regex", "has invalid format", compileRegexParam, validateRegex)
+	RegisterValidationRule("email", "must be a valid email address", func(f ValidationField) bool {
+		return f.Value.Kind() == reflect.String && emailRegexp.MatchString(f.Value.String())
+	})
+	RegisterValidationRule("url", "must be a valid url", func(f ValidationField) bool {
+		if f.Value.Kind() != reflect.String {
+			return false
+		}
+		u, err := url.ParseRequestURI(f.Value.String())
+		return err == nil && u.Scheme != "" && u.Host != ""
+	})
+	RegisterValidationRule("oneof", "must be one of %s", func(f ValidationField) bool {
+		value := fmt.Sprint(f.Value.Interface())
+		for _, option := range strings.Fields(f.Param) {
+			if value == option {
+				return true
+			}
+		}
+		return false
+	})
+	RegisterCrossFieldValidationRule("eqfield", "must be equal to %s", func(f ValidationField) bool {
+		other := f.Parent.FieldByName(f.Param)
+		return other.IsValid() && reflect.DeepEqual(f.Value.Interface(), other.Interface())
+	})
+	RegisterCrossFieldValidationRule("nefield", "must not be equal to %s", func(f ValidationField) bool {
+		other := f.Parent.FieldByName(f.Param)
+		return !other.IsValid() || !reflect.DeepEqual(f.Value.Interface(), other.Interface())
+	})
+}
+
+// RegisterValidationRule adds rule usable in `validate` struct tag, message may contain %s for the rule param.
+// Built-in rules are required, omitempty, min, max, len, regex, email, url, oneof, eqfield, nefield and dive.
+// Rules must be registered before struct types using them are validated, e.g. in init.
+func RegisterValidationRule(name string, message string, fn ValidationRuleFunc) {
+	registerValidationRule(name, message, nil, fn)
+}
+
+func registerValidationRule(name string, message string, checkParam func(param string) error, fn ValidationRuleFunc) {
+	validationRulesLock.Lock()
+	defer validationRulesLock.Unlock()
+	validationRules[name] = validationRule{fn: fn, message: message, checkParam: checkParam}
+}
+
+// RegisterCrossFieldValidationRule likes RegisterValidationRule for rules whose param is name of another
+// Go field of the parent struct. The param is shown as json key of that field in the message, and
+// the rejected value is never included since it may reveal the other field e.g. a password confirmation.
+func RegisterCrossFieldValidationRule(name string, message string, fn ValidationRuleFunc) {
+	validationRulesLock.Lock()
+	defer validationRulesLock.Unlock()
+	validationRules[name] = validationRule{fn: fn, message: message, crossField: true}
+}
+
+// CompileValidation parses `validate` tags of type of v and the struct types it contains, and returns error
+// of the first invalid one e.g. unknown rule, invalid regex or non-numeric min. Call it at startup to find
+// invalid tags before the first request, the result is cached for ValidateStruct.
+func CompileValidation(v interface{}) error {
+	return compileStructTypes(reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+func compileStructTypes(t reflect.Type, visited map[reflect.Type]bool) error {
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map) {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct || t == timeType || visited[t] {
+		return nil
+	}
+	visited[t] = true
+	compiled := compileStruct(t)
+	if compiled.err != nil {
+		return compiled.err
+	}
+	for _, field := range compiled.fields {
+		if err := compileStructTypes(t.Field(field.index).Type, visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileStruct returns cached rules of struct type t
+func compileStruct(t reflect.Type) *compiledStruct {
+	if cached, ok := compiledStructs.Load(t); ok {
+		return cached.(*compiledStruct)
+	}
+	compiled := &compiledStruct{}
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		if structField.PkgPath != "" {
+			continue
+		}
+		tag := structField.Tag.Get("validate")
+		if tag == "-" {
+			continue
+		}
+		rules, err := compileValidationRules(t, tag)
+		if err != nil {
+			compiled.err = fmt.Errorf("[Validator] invalid validate tag of %v.%v: %v", t, structField.Name, err)
+			break
+		}
+		compiled.fields = append(compiled.fields, compiledField{index: i, name: jsonFieldName(structField), rules: rules})
+	}
+	cached, _ := compiledStructs.LoadOrStore(t, compiled)
+	return cached.(*compiledStruct)
+}
+
+func compileValidationRules(parent reflect.Type, tag string) ([]compiledRule, error) {
+	rules := []compiledRule{}
+	for _, rule := range parseValidationRules(tag) {
+		name, param := rule, ""
+		if j := strings.Index(rule, "="); j >= 0 {
+			name, param = rule[:j], rule[j+1:]
+		}
+		if name == "required" || name == "omitempty" || name == "dive" {
+			rules = append(rules, compiledRule{name: name})
+			continue
+		}
+
+		validationRulesLock.RLock()
+		r, ok := validationRules[name]
+		validationRulesLock.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown validation rule %q", name)
+		}
+		if r.checkParam != nil {
+			if err := r.checkParam(param); err != nil {
+				return nil, fmt.Errorf("rule %q: %v", rule, err)
+			}
+		}
+		if r.crossField {
+			if _, ok := parent.FieldByName(param); !ok {
+				return nil, fmt.Errorf("rule %q: %v has no field %q", rule, parent, param)
+			}
+		}
+		rules = append(rules, compiledRule{name: name, param: param, rule: r})
+	}
+	return rules, nil
+}
+
+// ValidateStruct validates v by its `validate` struct tags and returns nil when it is valid.
+// Rules are comma separated e.g. `validate:"required,min=3,max=20"`, regex must be the last rule
+// since its pattern may contain commas. Rules are applied to zero values too, "required" rejects zero values
+// and "omitempty" skips the other rules of zero values. Nil pointers are skipped unless they are required.
+// "dive" applies the rules after it to every element of slice or map. Nested structs are always validated.
+// Field keys are taken from json tags e.g. "items[0].name".
+// Error is returned when a tag is invalid, see CompileValidation.
+func ValidateStruct(v interface{}) ([]FieldError, error) {
+	errs := []FieldError{}
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	if value.Kind() == reflect.Struct {
+		if err := validateStructValue(value, "", &errs); err != nil {
+			return nil, err
+		}
+	}
+	if len(errs) == 0 {
+		return nil, nil
+	}
+	return errs, nil
+}
+
+func validateStructValue(value reflect.Value, path string, errs *[]FieldError) error {
+	compiled := compileStruct(value.Type())
+	if compiled.err != nil {
+		return compiled.err
+	}
+	for _, field := range compiled.fields {
+		if err := validateValue(value.Field(field.index), value, joinFieldKey(path, field.name), field.rules, errs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateValue(value reflect.Value, parent reflect.Value, key string, rules []compiledRule, errs *[]FieldError) error {
+	required, omitEmpty := false, false
+	for _, rule := range rules {
+		if rule.name == "dive" {
+			break
+		}
+		required = required || rule.name == "required"
+		omitEmpty = omitEmpty || rule.name == "omitempty"
+	}
+	if isZeroValue(value) {
+		if required {
+			*errs = append(*errs, NewFieldError(key, "is required", "required", nil))
+			return nil
+		}
+		if omitEmpty {
+			return nil
+		}
+	}
+	value = indirectValue(value)
+	if (value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface) && value.IsNil() {
+		return nil
+	}
+
+	for i, rule := range rules {
+		switch rule.name {
+		case "required", "omitempty":
+			continue
+		case "dive":
+			diveRules := rules[i+1:]
+			switch value.Kind() {
+			case reflect.Slice, reflect.Array:
+				for j := 0; j < value.Len(); j++ {
+					if err := validateValue(value.Index(j), parent, fmt.Sprintf("%s[%d]", key, j), diveRules, errs); err != nil {
+						return err
+					}
+				}
+			case reflect.Map:
+				for _, mapKey := range value.MapKeys() {
+					if err := validateValue(value.MapIndex(mapKey), parent, fmt.Sprintf("%s[%v]", key, mapKey.Interface()), diveRules, errs); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+
+		r, param := rule.rule, rule.param
+		if !r.fn(ValidationField{Value: value, Param: param, Parent: parent}) {
+			if r.crossField {
+				param = jsonFieldNameOf(parent, param)
+			}
+			message := r.message
+			if strings.Contains(message, "%s") {
+				message = fmt.Sprintf(message, param)
+			}
+			var rejected interface{}
+			if !r.crossField {
+				rejected = rejectedValue(value)
+			}
+			*errs = append(*errs, NewFieldError(key, message, rule.name, rejected))
+			return nil
+		}
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		if value.Type() != timeType {
+			return validateStructValue(value, key, errs)
+		}
+	case reflect.Slice, reflect.Array:
+		for j := 0; j < value.Len(); j++ {
+			if element := indirectValue(value.Index(j)); element.Kind() == reflect.Struct && element.Type() != timeType {
+				if err := validateStructValue(element, fmt.Sprintf("%s[%d]", key, j), errs); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func parseValidationRules(tag string) []string {
+	rules := []string{}
+	for tag != "" {
+		if strings.HasPrefix(tag, "regex=") {
+			return append(rules, tag)
+		}
+		rule := tag
+		if i := strings.Index(tag, ","); i >= 0 {
+			rule, tag = tag[:i], tag[i+1:]
+		} else {
+			tag = ""
+		}
+		if rule = strings.TrimSpace(rule); rule != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func checkNumberParam(param string) error {
+	if _, err := strconv.ParseFloat(param, 64); err != nil {
+		return fmt.Errorf("param %q must be a number", param)
+	}
+	return nil
+}
+
+// compileRegexParam compiles pattern once, validateRegex finds it in validationRegexps
+func compileRegexParam(param string) error {
+	re, err := regexp.Compile(param)
+	if err != nil {
+		return err
+	}
+	validationRegexps.Store(param, re)
+	return nil
+}
+
+func validateRegex(f ValidationField) bool {
+	if f.Value.Kind() != reflect.String {
+		return false
+	}
+	re, ok := validationRegexps.Load(f.Param)
+	if !ok {
+		// the pattern is compiled with the struct type, custom rules calling validateRegex may pass another one
+		if compileRegexParam(f.Param) != nil {
+			return false
+		}
+		re, _ = validationRegexps.Load(f.Param)
+	}
+	return re.(*regexp.Regexp).MatchString(f.Value.String())
+}
+
+// compareSize compares number value, string length in characters or length of slice/map with param
+func compareSize(f ValidationField, compare func(size, param float64) bool) bool {
+	param, err := strconv.ParseFloat(f.Param, 64)
+	if err != nil {
+		return false
+	}
+	var size float64
+	switch f.Value.Kind() {
+	case reflect.String:
+		size = float64(utf8.RuneCountInString(f.Value.String()))
+	case reflect.Slice, reflect.Array, reflect.Map:
+		size = float64(f.Value.Len())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size = float64(f.Value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		size = float64(f.Value.Uint())
+	case reflect.Float32, reflect.Float64:
+		size = f.Value.Float()
+	default:
+		return false
+	}
+	return compare(size, param)
+}
+
+func isZeroValue(value reflect.Value) bool {
+	if !value.IsValid() {
+		return true
+	}
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
+
+func indirectValue(value reflect.Value) reflect.Value {
+	for (value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface) && !value.IsNil() {
+		value = value.Elem()
+	}
+	return value
+}
+
+// rejectedValue returns value only when it is a scalar which is safe to echo back
+func rejectedValue(value reflect.Value) interface{} {
+	switch value.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return value.Interface()
+	}
+	return nil
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// jsonFieldNameOf returns json key of Go field name of parent struct, name is returned when there is no such field
+func jsonFieldNameOf(parent reflect.Value, name string) string {
+	if parent.Kind() != reflect.Struct {
+		return name
+	}
+	if field, ok := parent.Type().FieldByName(name); ok {
+		return jsonFieldName(field)
+	}
+	return name
+}
+
+func joinFieldKey(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
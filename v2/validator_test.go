@@ -0,0 +1,204 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type validatorTestItem struct {
+	Name string `json:"name" validate:"required,max=5"`
+}
+
+type validatorTestAddress struct {
+	City string `json:"city" validate:"required"`
+}
+
+type validatorTestRequest struct {
+	Username             string               `json:"username" validate:"required,min=3,max=10"`
+	Email                string               `json:"email" validate:"email"`
+	Age                  int                  `json:"age" validate:"min=18,max=130"`
+	Role                 string               `json:"role" validate:"oneof=admin user"`
+	Code                 string               `json:"code" validate:"regex=^[A-Z]{2},[0-9]+$"`
+	Tags                 []string             `json:"tags" validate:"max=2,dive,min=2"`
+	Items                []validatorTestItem  `json:"items"`
+	Password             string               `json:"password" validate:"required"`
+	PasswordConfirmation string               `json:"passwordConfirmation" validate:"eqfield=Password"`
+	Nickname             string               `json:"nickname" validate:"nefield=Username"`
+	Color                string               `json:"color" validate:"validatortestcolor"`
+	Website              string               `json:"website" validate:"omitempty,url"`
+	Address              validatorTestAddress `json:"address"`
+}
+
+func init() {
+	RegisterValidationRule("validatortestcolor", "must be a color", func(f ValidationField) bool {
+		return f.Value.String() == "red" || f.Value.String() == "blue"
+	})
+}
+
+func validValidatorTestRequest() validatorTestRequest {
+	return validatorTestRequest{
+		Username:             "chanyut",
+		Email:                "chanyut@example.com",
+		Age:                  30,
+		Role:                 "admin",
+		Code:                 "TH,66",
+		Tags:                 []string{"go", "db"},
+		Items:                []validatorTestItem{{Name: "a"}},
+		Password:             "secret-1",
+		PasswordConfirmation: "secret-1",
+		Nickname:             "yut",
+		Color:                "red",
+		Address:              validatorTestAddress{City: "Bangkok"},
+	}
+}
+
+func TestValidateStruct(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(r *validatorTestRequest)
+		want   []FieldError
+	}{
+		{
+			name:   "valid",
+			modify: func(r *validatorTestRequest) {},
+		},
+		{
+			name:   "required",
+			modify: func(r *validatorTestRequest) { r.Username = "" },
+			want:   []FieldError{{Key: "username", Message: "is required", Code: "required"}},
+		},
+		{
+			name:   "min",
+			modify: func(r *validatorTestRequest) { r.Username = "ab" },
+			want:   []FieldError{{Key: "username", Message: "must be at least 3", Code: "min", RejectedValue: "ab"}},
+		},
+		{
+			name:   "max of number",
+			modify: func(r *validatorTestRequest) { r.Age = 131 },
+			want:   []FieldError{{Key: "age", Message: "must be at most 130", Code: "max", RejectedValue: 131}},
+		},
+		{
+			name:   "rules apply to zero values",
+			modify: func(r *validatorTestRequest) { r.Email = ""; r.Age = 0; r.Role = "" },
+			want: []FieldError{
+				{Key: "email", Message: "must be a valid email address", Code: "email", RejectedValue: ""},
+				{Key: "age", Message: "must be at least 18", Code: "min", RejectedValue: 0},
+				{Key: "role", Message: "must be one of admin user", Code: "oneof", RejectedValue: ""},
+			},
+		},
+		{
+			name:   "omitempty skips zero value",
+			modify: func(r *validatorTestRequest) { r.Website = "" },
+		},
+		{
+			name:   "omitempty applies rules to non-zero value",
+			modify: func(r *validatorTestRequest) { r.Website = "example" },
+			want:   []FieldError{{Key: "website", Message: "must be a valid url", Code: "url", RejectedValue: "example"}},
+		},
+		{
+			name:   "zero nested struct is validated",
+			modify: func(r *validatorTestRequest) { r.Address = validatorTestAddress{} },
+			want:   []FieldError{{Key: "address.city", Message: "is required", Code: "required"}},
+		},
+		{
+			name:   "email",
+			modify: func(r *validatorTestRequest) { r.Email = "chanyut" },
+			want:   []FieldError{{Key: "email", Message: "must be a valid email address", Code: "email", RejectedValue: "chanyut"}},
+		},
+		{
+			name:   "oneof",
+			modify: func(r *validatorTestRequest) { r.Role = "root" },
+			want:   []FieldError{{Key: "role", Message: "must be one of admin user", Code: "oneof", RejectedValue: "root"}},
+		},
+		{
+			name:   "regex with comma",
+			modify: func(r *validatorTestRequest) { r.Code = "TH66" },
+			want:   []FieldError{{Key: "code", Message: "has invalid format", Code: "regex", RejectedValue: "TH66"}},
+		},
+		{
+			name:   "dive",
+			modify: func(r *validatorTestRequest) { r.Tags = []string{"go", "x"} },
+			want:   []FieldError{{Key: "tags[1]", Message: "must be at least 2", Code: "min", RejectedValue: "x"}},
+		},
+		{
+			name:   "rules before dive apply to the slice",
+			modify: func(r *validatorTestRequest) { r.Tags = []string{"go", "db", "js"} },
+			want:   []FieldError{{Key: "tags", Message: "must be at most 2", Code: "max"}},
+		},
+		{
+			name:   "nested struct in slice",
+			modify: func(r *validatorTestRequest) { r.Items = []validatorTestItem{{Name: "a"}, {Name: ""}} },
+			want:   []FieldError{{Key: "items[1].name", Message: "is required", Code: "required"}},
+		},
+		{
+			name:   "eqfield omits rejected value and uses json key",
+			modify: func(r *validatorTestRequest) { r.PasswordConfirmation = "secret-2" },
+			want:   []FieldError{{Key: "passwordConfirmation", Message: "must be equal to password", Code: "eqfield"}},
+		},
+		{
+			name:   "nefield omits rejected value and uses json key",
+			modify: func(r *validatorTestRequest) { r.Nickname = "chanyut" },
+			want:   []FieldError{{Key: "nickname", Message: "must not be equal to username", Code: "nefield"}},
+		},
+		{
+			name:   "custom rule",
+			modify: func(r *validatorTestRequest) { r.Color = "green" },
+			want:   []FieldError{{Key: "color", Message: "must be a color", Code: "validatortestcolor", RejectedValue: "green"}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := validValidatorTestRequest()
+			c.modify(&req)
+			got, err := ValidateStruct(&req)
+			if err != nil {
+				t.Fatalf("ValidateStruct() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("ValidateStruct() = %#v, want %#v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestValidateStructInvalidRules(t *testing.T) {
+	cases := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"unknown rule", struct {
+			Name string `validate:"nosuchrule"`
+		}{}, `unknown validation rule "nosuchrule"`},
+		{"invalid regex", struct {
+			Name string `validate:"regex=[a-"`
+		}{}, `rule "regex=[a-"`},
+		{"non-numeric min", struct {
+			Name string `validate:"min=three"`
+		}{}, `param "three" must be a number`},
+		{"eqfield to missing field", struct {
+			Name string `validate:"eqfield=Other"`
+		}{}, `has no field "Other"`},
+		{"invalid nested struct", struct {
+			Items []struct {
+				Name string `validate:"max=x"`
+			}
+		}{Items: make([]struct {
+			Name string `validate:"max=x"`
+		}, 1)}, `param "x" must be a number`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := CompileValidation(c.value)
+			if err == nil || !strings.Contains(err.Error(), c.want) || !strings.HasPrefix(err.Error(), "[Validator]") {
+				t.Fatalf("CompileValidation() error %v, want %q", err, c.want)
+			}
+			if _, err := ValidateStruct(c.value); err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("ValidateStruct() error %v, want %q", err, c.want)
+			}
+		})
+	}
+}